@@ -0,0 +1,64 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Reasons of response write errors
+const (
+	writeErrorBrokenPipe      string = "broken_pipe"
+	writeErrorConnectionReset string = "connection_reset"
+	writeErrorTimeout         string = "timeout"
+	writeErrorOther           string = "other"
+)
+
+// abortWatch notices a client going away while the handler is running.
+// It is not part of the pooled writer: the callback may still run after
+// the request is recorded
+type abortWatch struct {
+	ctx  context.Context
+	stop func() bool
+	at   atomic.Int64 // unix nanoseconds of the cancellation
+}
+
+func watchAbort(ctx context.Context) *abortWatch {
+	a := &abortWatch{ctx: ctx}
+	a.stop = context.AfterFunc(ctx, func() {
+		a.at.Store(time.Now().UnixNano())
+	})
+	return a
+}
+
+// done stops watching and returns when the client went away, if it did.
+// Deadlines set by other middleware are not aborts. If the callback did
+// not run yet, now is used
+func (a *abortWatch) done(now time.Time) (time.Time, bool) {
+	if a.stop() || !errors.Is(a.ctx.Err(), context.Canceled) {
+		return time.Time{}, false
+	}
+	if at := a.at.Load(); at != 0 {
+		return time.Unix(0, at), true
+	}
+	return now, true
+}
+
+// writeErrorReason classifies an error returned while writing the response
+func writeErrorReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.EPIPE):
+		return writeErrorBrokenPipe
+	case errors.Is(err, syscall.ECONNRESET):
+		return writeErrorConnectionReset
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return writeErrorTimeout
+	default:
+		return writeErrorOther
+	}
+}
@@ -0,0 +1,209 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error timing out without wrapping os.ErrDeadlineExceeded
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestWriteErrorReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}, writeErrorBrokenPipe},
+		{fmt.Errorf("response: %w", syscall.ECONNRESET), writeErrorConnectionReset},
+		{&net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}, writeErrorTimeout},
+		{timeoutError{}, writeErrorTimeout},
+		{http.ErrHandlerTimeout, writeErrorOther},
+		{errors.New("short write"), writeErrorOther},
+	}
+
+	for _, tt := range tests {
+		if got := writeErrorReason(tt.err); got != tt.want {
+			t.Errorf("writeErrorReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestAbortWatch(t *testing.T) {
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		a := watchAbort(ctx)
+		before := time.Now()
+		cancel()
+
+		// the callback runs in its own goroutine
+		deadline := time.Now().Add(time.Second)
+		for a.at.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+
+		at, aborted := a.done(time.Now().Add(time.Hour))
+		if !aborted {
+			t.Fatal("cancellation not reported as an abort")
+		}
+		if at.Before(before) || at.After(time.Now()) {
+			t.Errorf("aborted at %v, want the cancellation time", at)
+		}
+	})
+
+	t.Run("canceled before the callback ran", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// the callback was started but did not store the time yet
+		a := &abortWatch{ctx: ctx, stop: func() bool { return false }}
+
+		now := time.Now()
+		if at, aborted := a.done(now); !aborted || !at.Equal(now) {
+			t.Errorf("done = %v, %v; want an abort at %v", at, aborted, now)
+		}
+	})
+
+	t.Run("not canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		a := watchAbort(ctx)
+		if _, aborted := a.done(time.Now()); aborted {
+			t.Error("abort reported for a live request")
+		}
+		// canceling once done is not an abort of the request anymore
+		cancel()
+		if a.at.Load() != 0 {
+			t.Error("callback ran after done")
+		}
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		a := watchAbort(ctx)
+		<-ctx.Done()
+		if _, aborted := a.done(time.Now()); aborted {
+			t.Error("deadline reported as an abort")
+		}
+	})
+}
+
+func TestMiddlewareClientAbort(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.EndpointPatterns.Rules = []PatternRule{{Pattern: "^/slow$", Name: "slow"}}
+	})
+
+	started := make(chan struct{})
+	srv, done := newTestServer(t, p, func(_ http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := srv.Client().Do(req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("request error %v, want context.Canceled", err)
+	}
+	<-done
+
+	families := gather(t, p)
+	labels := map[string]string{"endpoint": "slow"}
+	if v := metricValue(families, "rr_http_client_aborts_total", labels); v != 1 {
+		t.Errorf("client_aborts_total{endpoint=slow} = %v, want 1", v)
+	}
+	if v := metricValue(families, "rr_http_client_abort_elapsed_seconds", labels); v != 1 {
+		t.Errorf("client_abort_elapsed_seconds count = %v, want 1", v)
+	}
+	if v := metricValue(families, "rr_http_request_total", map[string]string{"status": "499"}); v != 1 {
+		t.Errorf("request_total{status=499} = %v, want 1", v)
+	}
+}
+
+func TestMiddlewareNoAbort(t *testing.T) {
+	for _, collect := range []bool{true, false} {
+		p := newTestPlugin(t, func(cfg *Config) {
+			cfg.CollectAborts = collect
+		})
+
+		serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		families := gather(t, p)
+		if _, ok := families["rr_http_client_aborts_total"]; ok {
+			t.Errorf("collect_aborts %v: client_aborts_total recorded for a completed request", collect)
+		}
+	}
+}
+
+// failingWriter fails every write with err
+type failingWriter struct {
+	http.ResponseWriter
+	err error
+}
+
+func (f failingWriter) Write([]byte) (int, error) { return 0, f.err }
+
+func (f failingWriter) ReadFrom(io.Reader) (int64, error) { return 0, f.err }
+
+func TestMiddlewareWriteErrors(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	tests := []struct {
+		err      error
+		readFrom bool
+	}{
+		{os.NewSyscallError("write", syscall.EPIPE), false},
+		{os.NewSyscallError("write", syscall.EPIPE), true},
+		{os.NewSyscallError("write", syscall.ECONNRESET), false},
+		{os.ErrDeadlineExceeded, true},
+	}
+
+	for _, tt := range tests {
+		w := failingWriter{ResponseWriter: httptest.NewRecorder(), err: tt.err}
+		p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			var err error
+			if tt.readFrom {
+				_, err = w.(io.ReaderFrom).ReadFrom(strings.NewReader("body"))
+			} else {
+				_, err = w.Write([]byte("body"))
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("write error %v, want %v", err, tt.err)
+			}
+			// only the first error of a response counts
+			_, _ = w.Write([]byte("more"))
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	families := gather(t, p)
+	for reason, want := range map[string]float64{
+		writeErrorBrokenPipe:      2,
+		writeErrorConnectionReset: 1,
+		writeErrorTimeout:         1,
+		writeErrorOther:           -1,
+	} {
+		if v := metricValue(families, "rr_http_write_errors_total", map[string]string{"reason": reason}); v != want {
+			t.Errorf("write_errors_total{reason=%s} = %v, want %v", reason, v, want)
+		}
+	}
+}
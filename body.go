@@ -0,0 +1,56 @@
+package prometheus
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// requestBody wraps the request body to count the bytes actually read.
+// Content-Length is -1 for chunked uploads and may not match what the
+// client sends, so it is only recorded as the declared size.
+// It is not part of the pooled writer: the handler may hand the body to a
+// goroutine that keeps reading after the request is recorded
+type requestBody struct {
+	rc io.ReadCloser
+
+	// declared is the Content-Length of the request, -1 if unknown
+	declared int64
+	read     atomic.Int64
+	eof      atomic.Bool
+}
+
+// wrapBody replaces the request body with a counting reader. Returns nil
+// for requests without a body, they are left as they are
+func wrapBody(r *http.Request) *requestBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	b := &requestBody{rc: r.Body, declared: r.ContentLength}
+	r.Body = b
+	return b
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.read.Add(int64(n))
+	if errors.Is(err, io.EOF) {
+		b.eof.Store(true)
+	}
+	return n, err
+}
+
+func (b *requestBody) Close() error {
+	return b.rc.Close()
+}
+
+// unconsumed reports whether the body was not read to the end. A body read
+// up to its declared length counts as consumed even if EOF was never seen
+func (b *requestBody) unconsumed() bool {
+	if b.eof.Load() || b.declared == 0 {
+		return false
+	}
+	return b.declared < 0 || b.read.Load() < b.declared
+}
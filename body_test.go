@@ -0,0 +1,140 @@
+package prometheus
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readBody returns a handler reading at most n bytes of the request body, all of it if n < 0
+func readBody(n int64) http.HandlerFunc {
+	return func(_ http.ResponseWriter, r *http.Request) {
+		if n < 0 {
+			_, _ = io.Copy(io.Discard, r.Body)
+			return
+		}
+		_, _ = io.CopyN(io.Discard, r.Body, n)
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		chunked    bool
+		read       int64
+		size       float64 // request_size_bytes sum, -1 without a sample
+		unconsumed float64
+	}{
+		{"read fully", "0123456789", false, -1, 10, -1},
+		{"read up to the length", "0123456789", false, 10, 10, -1},
+		{"partially read", "0123456789", false, 4, 4, 1},
+		{"not read", "0123456789", false, 0, -1, 1},
+		{"chunked read fully", "0123456789", true, -1, 10, -1},
+		// without a length only EOF tells that the body was consumed
+		{"chunked up to the end", "0123456789", true, 10, 10, 1},
+		{"chunked not read", "0123456789", true, 0, -1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, func(cfg *Config) {
+				cfg.CollectDeclaredSize = true
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(tt.body))
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			serveRequest(p, readBody(tt.read), r)
+
+			families := gather(t, p)
+			labels := map[string]string{"method": "POST", "endpoint": "other"}
+			size := -1.0
+			if m := findMetric(families, "rr_http_request_size_bytes", labels); m != nil {
+				size = m.GetHistogram().GetSampleSum()
+			}
+			if size != tt.size {
+				t.Errorf("request_size_bytes sum = %v, want %v", size, tt.size)
+			}
+			if v := metricValue(families, "rr_http_request_body_unconsumed_total", labels); v != tt.unconsumed {
+				t.Errorf("request_body_unconsumed_total = %v, want %v", v, tt.unconsumed)
+			}
+
+			declared := metricValue(families, "rr_http_request_declared_size_bytes", labels)
+			if want := map[bool]float64{false: 1, true: -1}[tt.chunked]; declared != want {
+				t.Errorf("request_declared_size_bytes count = %v, want %v", declared, want)
+			}
+		})
+	}
+}
+
+func TestRequestBodyNone(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectDeclaredSize = true
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	serveRequest(p, func(_ http.ResponseWriter, r *http.Request) {
+		if r.Body != http.NoBody {
+			t.Errorf("body = %T, want http.NoBody", r.Body)
+		}
+	}, r)
+
+	families := gather(t, p)
+	for _, name := range []string{
+		"rr_http_request_size_bytes",
+		"rr_http_request_declared_size_bytes",
+		"rr_http_request_body_unconsumed_total",
+	} {
+		if _, ok := families[name]; ok {
+			t.Errorf("%s recorded for a request without a body", name)
+		}
+	}
+}
+
+func TestRequestBodyReadAfterReturn(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	// the handler leaves the body to a goroutine that reads it once the
+	// request has been recorded and the writer went back to the pool
+	var late io.ReadCloser
+	serveRequest(p, func(_ http.ResponseWriter, r *http.Request) {
+		late = r.Body
+	}, httptest.NewRequest(http.MethodPost, "/late", strings.NewReader("late body")))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(io.Discard, late)
+		_ = late.Close()
+	}()
+	serveRequest(p, readBody(-1), httptest.NewRequest(http.MethodPost, "/next", strings.NewReader("next")))
+	<-done
+
+	m := findMetric(gather(t, p), "rr_http_request_size_bytes", map[string]string{"method": "POST", "endpoint": "other"})
+	if m == nil {
+		t.Fatal("no request_size_bytes series")
+	}
+	// only the next request read its body while it was served
+	if h := m.GetHistogram(); h.GetSampleCount() != 1 || h.GetSampleSum() != 4 {
+		t.Errorf("request_size_bytes count %d, sum %v; want 1 sample of 4 bytes", h.GetSampleCount(), h.GetSampleSum())
+	}
+}
+
+func TestRequestBodySizesDisabled(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectSizes = false
+	})
+
+	body := strings.NewReader("body")
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	original := r.Body
+	serveRequest(p, func(_ http.ResponseWriter, r *http.Request) {
+		if r.Body != original {
+			t.Error("body wrapped with collect_sizes disabled")
+		}
+	}, r)
+}
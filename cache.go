@@ -0,0 +1,250 @@
+package prometheus
+
+import (
+	"hash/maphash"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// maxCacheShards bounds the number of shards a cache is split into
+	maxCacheShards = 64
+
+	// minShardCapacity keeps shards large enough for LRU eviction to be meaningful
+	minShardCapacity = 16
+)
+
+// endpointCache is a sharded LRU cache for endpoint matching results.
+// Each shard has its own lock and recency list, so concurrent requests for
+// different paths rarely contend. Keys longer than maxKeyLength are never
+// stored to keep memory bounded for long or hostile URLs
+type endpointCache struct {
+	seed         maphash.Seed
+	shards       []cacheShard
+	mask         uint64
+	maxKeyLength int
+}
+
+// cacheShard is a single LRU with its own statistics, updated under its lock
+type cacheShard struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*cacheNode
+	head     *cacheNode
+	tail     *cacheNode
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	bypassed  uint64
+
+	// pad keeps neighbouring shard locks on separate cache lines
+	_ [64]byte
+}
+
+type cacheNode struct {
+	key   string
+	value endpointResult
+	prev  *cacheNode
+	next  *cacheNode
+}
+
+// cacheStats is a snapshot of the cache statistics summed over all shards
+type cacheStats struct {
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	bypassed  uint64
+	size      int
+}
+
+func newEndpointCache(capacity, maxKeyLength int) *endpointCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	shards := 1
+	for shards < maxCacheShards && shards*2*minShardCapacity <= capacity {
+		shards *= 2
+	}
+
+	c := &endpointCache{
+		seed:         maphash.MakeSeed(),
+		shards:       make([]cacheShard, shards),
+		mask:         uint64(shards - 1),
+		maxKeyLength: maxKeyLength,
+	}
+
+	// spread the capacity so that the total never exceeds the configured size
+	for i := range c.shards {
+		c.shards[i].capacity = capacity / shards
+		if i < capacity%shards {
+			c.shards[i].capacity++
+		}
+		c.shards[i].items = make(map[string]*cacheNode)
+	}
+
+	return c
+}
+
+func (c *endpointCache) shard(key string) *cacheShard {
+	return &c.shards[maphash.String(c.seed, key)&c.mask]
+}
+
+// cacheable reports whether the key is short enough to be stored
+func (c *endpointCache) cacheable(key string) bool {
+	return c.maxKeyLength <= 0 || len(key) <= c.maxKeyLength
+}
+
+// Get returns the cached value and marks the entry as most recently used
+func (c *endpointCache) Get(key string) (endpointResult, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !c.cacheable(key) {
+		s.bypassed++
+		return endpointResult{}, false
+	}
+
+	node, ok := s.items[key]
+	if !ok {
+		s.misses++
+		return endpointResult{}, false
+	}
+
+	s.hits++
+	s.moveToFront(node)
+	return node.value, true
+}
+
+// Set stores a value, evicting the least recently used entry of the shard if full
+func (c *endpointCache) Set(key string, value endpointResult) {
+	if !c.cacheable(key) {
+		return
+	}
+
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if node, ok := s.items[key]; ok {
+		node.value = value
+		s.moveToFront(node)
+		return
+	}
+
+	node := &cacheNode{key: key, value: value}
+	s.items[key] = node
+	s.addToFront(node)
+
+	if len(s.items) > s.capacity {
+		s.evict()
+	}
+}
+
+// Purge drops all entries, statistics are kept
+func (c *endpointCache) Purge() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.items = make(map[string]*cacheNode)
+		s.head = nil
+		s.tail = nil
+		s.mu.Unlock()
+	}
+}
+
+// stats sums the statistics of all shards
+func (c *endpointCache) stats() cacheStats {
+	var st cacheStats
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		st.hits += s.hits
+		st.misses += s.misses
+		st.evictions += s.evictions
+		st.bypassed += s.bypassed
+		st.size += len(s.items)
+		s.mu.Unlock()
+	}
+	return st
+}
+
+// collectors returns the cache self-metrics. Values are read from the shards
+// at scrape time, so the hot path only touches its own shard
+func (c *endpointCache) collectors() []prometheus.Collector {
+	counter := func(name, help string, value func(cacheStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return float64(value(c.stats()))
+		})
+	}
+
+	return []prometheus.Collector{
+		counter("endpoint_cache_hits_total", "Total number of endpoint lookups served from the cache.",
+			func(st cacheStats) uint64 { return st.hits }),
+		counter("endpoint_cache_misses_total", "Total number of endpoint lookups that missed the cache.",
+			func(st cacheStats) uint64 { return st.misses }),
+		counter("endpoint_cache_evictions_total", "Total number of endpoint cache entries evicted to make room for new ones.",
+			func(st cacheStats) uint64 { return st.evictions }),
+		counter("endpoint_cache_bypassed_total", "Total number of endpoint lookups not cached because the key exceeded cache_max_key_length.",
+			func(st cacheStats) uint64 { return st.bypassed }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "endpoint_cache_size",
+			Help:      "Current number of entries in the endpoint cache.",
+		}, func() float64 {
+			return float64(c.stats().size)
+		}),
+	}
+}
+
+func (s *cacheShard) addToFront(node *cacheNode) {
+	node.prev = nil
+	node.next = s.head
+	if s.head != nil {
+		s.head.prev = node
+	}
+	s.head = node
+	if s.tail == nil {
+		s.tail = node
+	}
+}
+
+func (s *cacheShard) remove(node *cacheNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		s.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		s.tail = node.prev
+	}
+	node.prev = nil
+	node.next = nil
+}
+
+func (s *cacheShard) moveToFront(node *cacheNode) {
+	if s.head == node {
+		return
+	}
+	s.remove(node)
+	s.addToFront(node)
+}
+
+func (s *cacheShard) evict() {
+	if s.tail == nil {
+		return
+	}
+	node := s.tail
+	s.remove(node)
+	delete(s.items, node.key)
+	s.evictions++
+}
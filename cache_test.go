@@ -0,0 +1,203 @@
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestEndpointCacheRecency(t *testing.T) {
+	// below 2*minShardCapacity the cache is a single LRU
+	c := newEndpointCache(3, 0)
+	if len(c.shards) != 1 {
+		t.Fatalf("shards = %d, want 1", len(c.shards))
+	}
+
+	for _, key := range []string{"/a", "/b", "/c"} {
+		c.Set(key, endpointResult{label: key})
+	}
+
+	// /a becomes the most recently used, /b is now the eviction candidate
+	if res, ok := c.Get("/a"); !ok || res.label != "/a" {
+		t.Fatalf("Get(/a) = %+v, %v", res, ok)
+	}
+	c.Set("/d", endpointResult{label: "/d"})
+
+	if _, ok := c.Get("/b"); ok {
+		t.Error("/b survived eviction")
+	}
+	for _, key := range []string{"/a", "/c", "/d"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	// updating an entry refreshes it as well
+	c.Set("/a", endpointResult{label: "/a2"})
+	c.Set("/e", endpointResult{label: "/e"})
+	c.Set("/f", endpointResult{label: "/f"})
+	if res, ok := c.Get("/a"); !ok || res.label != "/a2" {
+		t.Errorf("Get(/a) = %+v, %v; want the updated entry", res, ok)
+	}
+
+	if st := c.stats(); st.size != 3 || st.evictions != 3 {
+		t.Errorf("stats = %+v, want size 3 and 3 evictions", st)
+	}
+}
+
+func TestEndpointCacheShards(t *testing.T) {
+	tests := []struct {
+		capacity int
+		shards   int
+	}{
+		{0, 1},
+		{31, 1},
+		{32, 2},
+		{1000, 32},
+		{1023, 32},
+		{1024, 64},
+		{1 << 20, maxCacheShards},
+	}
+
+	for _, tt := range tests {
+		c := newEndpointCache(tt.capacity, 0)
+		if len(c.shards) != tt.shards {
+			t.Errorf("capacity %d: shards = %d, want %d", tt.capacity, len(c.shards), tt.shards)
+		}
+
+		total := 0
+		for i := range c.shards {
+			total += c.shards[i].capacity
+		}
+		if want := max(tt.capacity, 1); total != want {
+			t.Errorf("capacity %d: shard capacities sum to %d", tt.capacity, total)
+		}
+	}
+}
+
+func TestEndpointCacheShardCapacity(t *testing.T) {
+	const capacity = 1000
+	c := newEndpointCache(capacity, 0)
+
+	for i := 0; i < 10*capacity; i++ {
+		c.Set(fmt.Sprintf("/items/%d", i), endpointResult{label: "item"})
+	}
+
+	for i := range c.shards {
+		s := &c.shards[i]
+		if len(s.items) > s.capacity {
+			t.Errorf("shard %d holds %d entries, capacity %d", i, len(s.items), s.capacity)
+		}
+	}
+	st := c.stats()
+	if st.size > capacity {
+		t.Errorf("size = %d, exceeds %d", st.size, capacity)
+	}
+	if int(st.evictions)+st.size != 10*capacity {
+		t.Errorf("evictions %d + size %d, want %d", st.evictions, st.size, 10*capacity)
+	}
+}
+
+func TestEndpointCacheMaxKeyLength(t *testing.T) {
+	c := newEndpointCache(10, 8)
+
+	long := "/" + strings.Repeat("a", 8)
+	c.Set(long, endpointResult{label: "long"})
+	if _, ok := c.Get(long); ok {
+		t.Error("key longer than the limit was cached")
+	}
+
+	c.Set("/short", endpointResult{label: "short"})
+	if _, ok := c.Get("/short"); !ok {
+		t.Error("key within the limit was not cached")
+	}
+
+	if st := c.stats(); st.bypassed != 1 || st.misses != 0 || st.hits != 1 || st.size != 1 {
+		t.Errorf("stats = %+v, want 1 bypass, 1 hit and no misses", st)
+	}
+
+	// 0 disables the limit
+	c = newEndpointCache(10, 0)
+	c.Set(long, endpointResult{label: "long"})
+	if _, ok := c.Get(long); !ok {
+		t.Error("key was not cached without a limit")
+	}
+}
+
+func TestEndpointCachePurge(t *testing.T) {
+	c := newEndpointCache(100, 0)
+	c.Set("/a", endpointResult{label: "a"})
+	c.Get("/a")
+	c.Purge()
+
+	if _, ok := c.Get("/a"); ok {
+		t.Error("entry survived Purge")
+	}
+	// statistics survive a purge
+	if st := c.stats(); st.size != 0 || st.hits != 1 || st.misses != 1 {
+		t.Errorf("stats = %+v, want size 0, 1 hit and 1 miss", st)
+	}
+}
+
+func TestEndpointCacheMetrics(t *testing.T) {
+	c := newEndpointCache(1, 4)
+	c.Get("/a")                      // miss
+	c.Set("/a", endpointResult{})    //
+	c.Get("/a")                      // hit
+	c.Set("/b", endpointResult{})    // evicts /a
+	c.Get("/long")                   // bypass
+	c.Set("/long", endpointResult{}) // not stored
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c.collectors()...)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+
+	for name, want := range map[string]float64{
+		"rr_http_endpoint_cache_hits_total":      1,
+		"rr_http_endpoint_cache_misses_total":    1,
+		"rr_http_endpoint_cache_evictions_total": 1,
+		"rr_http_endpoint_cache_bypassed_total":  1,
+		"rr_http_endpoint_cache_size":            1,
+	} {
+		if v := metricValue(byName, name, nil); v != want {
+			t.Errorf("%s = %v, want %v", name, v, want)
+		}
+	}
+}
+
+func BenchmarkEndpointCacheGet(b *testing.B) {
+	const keys = 4096
+
+	// room for uneven shards, every lookup is a hit
+	c := newEndpointCache(2*keys, 0)
+	paths := make([]string, keys)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/api/resource/%d", i)
+		c.Set(paths[i], endpointResult{label: "resource"})
+	}
+
+	var next atomic.Uint64
+	b.ReportAllocs()
+	// 64 goroutines per GOMAXPROCS
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := next.Add(1) * 7919
+		for pb.Next() {
+			c.Get(paths[i%keys])
+			i++
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CardinalityEstimator replays requests, e.g. from access logs, through the
+// same endpoint matching and error classification the plugin uses, and counts
+// the label sets the endpoint-level metrics would produce
+type CardinalityEstimator struct {
+	p *Plugin
+
+	requests  int
+	excluded  int
+	endpoints map[string]int
+
+	requestsByEndpoint map[string]struct{}
+	// durationByEndpoint maps label sets to their bucket profile
+	durationByEndpoint map[string]string
+	errorsByType       map[string]struct{}
+}
+
+// MetricCardinality is the estimated size of one metric
+type MetricCardinality struct {
+	Name string
+
+	// LabelSets is the number of distinct label combinations
+	LabelSets int
+
+	// Series is the number of time series, including histogram buckets, _sum and _count
+	Series int
+}
+
+// EndpointCount is the number of requests labelled with an endpoint
+type EndpointCount struct {
+	Endpoint string
+	Requests int
+}
+
+// CardinalityReport summarizes a replay
+type CardinalityReport struct {
+	Requests int
+	Metrics  []MetricCardinality
+
+	// Excluded is the number of requests left out by the exclude configuration.
+	// They are not part of Requests
+	Excluded int
+
+	// Endpoints is the number of distinct endpoint label values
+	Endpoints    int
+	TopEndpoints []EndpointCount
+
+	// OtherShare is the share of requests (0-1) that matched no rule and fell into "other"
+	OtherShare float64
+
+	// OverflowShare is the share of requests (0-1) collapsed by max_patterns
+	OverflowShare float64
+}
+
+// NewCardinalityEstimator creates an estimator for the configuration.
+// Rules from EndpointPatterns.RulesFile are loaded as well
+func NewCardinalityEstimator(cfg *Config) (*CardinalityEstimator, error) {
+	p := &Plugin{config: cfg}
+	if err := p.init(); err != nil {
+		return nil, err
+	}
+
+	return &CardinalityEstimator{
+		p:                  p,
+		endpoints:          make(map[string]int),
+		requestsByEndpoint: make(map[string]struct{}),
+		durationByEndpoint: make(map[string]string),
+		errorsByType:       make(map[string]struct{}),
+	}, nil
+}
+
+// Add replays a single request with the response status it got
+func (e *CardinalityEstimator) Add(req RequestInfo, status int) {
+	// access logs carry no user agent or peer address, only paths, methods and endpoints can match
+	r := &http.Request{Method: req.Method, Host: req.Host, URL: &url.URL{Path: req.Path}, Header: http.Header{}}
+	excluded, probe := e.p.excluded(r, req)
+	if excluded {
+		e.excluded++
+		return
+	}
+
+	result := e.p.endpoint(req, "", probe)
+	endpoint := result.label
+	host := e.p.hostLabel(req.Host)
+	code := strconv.Itoa(status)
+
+	e.requests++
+	e.endpoints[endpoint]++
+
+	labels := []string{req.Method, endpoint}
+	if e.p.config.GroupLabel {
+		labels = append(labels, result.group)
+	}
+	if e.p.config.HostLabel.Enabled {
+		labels = append(labels, host)
+	}
+	// like the plugin, a label set keeps the profile it was first seen with
+	key := labelSetKey(labels...)
+	if _, ok := e.durationByEndpoint[key]; !ok {
+		e.durationByEndpoint[key] = result.buckets
+	}
+	e.requestsByEndpoint[labelSetKey(append(labels, code)...)] = struct{}{}
+
+	if isErrorStatus(status) {
+		// access logs carry no response headers, so no_workers cannot be detected
+		errorType := string(classifyError(status, http.Header{}))
+		errLabels := []string{errorType, endpoint, code}
+		if e.p.config.GroupLabel {
+			errLabels = append(errLabels, result.group)
+		}
+		if e.p.config.HostLabel.Enabled {
+			errLabels = append(errLabels, host)
+		}
+		e.errorsByType[labelSetKey(errLabels...)] = struct{}{}
+	}
+}
+
+// Report returns the estimate with the top most frequent endpoints
+func (e *CardinalityEstimator) Report(top int) CardinalityReport {
+	durationSeries := 0
+	for _, profile := range e.durationByEndpoint {
+		buckets := e.p.config.DurationBuckets
+		if profile != "" {
+			buckets = e.p.config.BucketProfiles[profile]
+		}
+		durationSeries += len(buckets) + 3 // +Inf bucket, _sum, _count
+	}
+
+	report := CardinalityReport{
+		Requests: e.requests,
+		Excluded: e.excluded,
+		Metrics: []MetricCardinality{
+			{
+				Name:      namespace + "_requests_by_endpoint_total",
+				LabelSets: len(e.requestsByEndpoint),
+				Series:    len(e.requestsByEndpoint),
+			},
+			{
+				Name:      namespace + "_duration_by_endpoint_seconds",
+				LabelSets: len(e.durationByEndpoint),
+				Series:    durationSeries,
+			},
+			{
+				Name:      namespace + "_errors_total",
+				LabelSets: len(e.errorsByType),
+				Series:    len(e.errorsByType),
+			},
+		},
+		Endpoints: len(e.endpoints),
+	}
+
+	counts := make([]EndpointCount, 0, len(e.endpoints))
+	for endpoint, n := range e.endpoints {
+		counts = append(counts, EndpointCount{Endpoint: endpoint, Requests: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Requests != counts[j].Requests {
+			return counts[i].Requests > counts[j].Requests
+		}
+		return counts[i].Endpoint < counts[j].Endpoint
+	})
+	if top >= 0 && len(counts) > top {
+		counts = counts[:top]
+	}
+	report.TopEndpoints = counts
+
+	if e.requests > 0 {
+		report.OtherShare = float64(e.endpoints[e.p.endpointMatcher.fallback]) / float64(e.requests)
+		if e.p.endpointMatcher.maxPatterns > 0 {
+			report.OverflowShare = float64(e.endpoints[e.p.endpointMatcher.overflowLabel]) / float64(e.requests)
+		}
+	}
+
+	return report
+}
+
+// TotalSeries sums the series of all reported metrics
+func (r CardinalityReport) TotalSeries() int {
+	total := 0
+	for _, m := range r.Metrics {
+		total += m.Series
+	}
+	return total
+}
+
+func labelSetKey(values ...string) string {
+	return strings.Join(values, "\xff")
+}
@@ -0,0 +1,142 @@
+package prometheus
+
+import (
+	"testing"
+)
+
+func newTestEstimator(t *testing.T, configure func(*Config)) *CardinalityEstimator {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.EndpointPatterns.Enabled = true
+	cfg.EndpointPatterns.CacheSize = 100
+	if configure != nil {
+		configure(cfg)
+	}
+
+	e, err := NewCardinalityEstimator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func metricCardinality(report CardinalityReport, name string) MetricCardinality {
+	for _, m := range report.Metrics {
+		if m.Name == name {
+			return m
+		}
+	}
+	return MetricCardinality{Name: name, LabelSets: -1, Series: -1}
+}
+
+func TestCardinalityEstimator(t *testing.T) {
+	e := newTestEstimator(t, func(cfg *Config) {
+		cfg.DurationBuckets = []float64{0.1, 1}
+		cfg.BucketProfiles = map[string][]float64{"slow": {1, 10, 60}}
+		cfg.EndpointPatterns.Rules = []PatternRule{
+			{Route: "/users/{id:int}", Name: "user"},
+			{Route: "/reports/{id:int}", Name: "report", Buckets: "slow"},
+		}
+	})
+
+	e.Add(RequestInfo{Method: "GET", Path: "/users/1"}, 200)
+	e.Add(RequestInfo{Method: "GET", Path: "/users/2"}, 200)
+	e.Add(RequestInfo{Method: "GET", Path: "/users/3"}, 404)
+	e.Add(RequestInfo{Method: "DELETE", Path: "/users/3"}, 500)
+	e.Add(RequestInfo{Method: "GET", Path: "/reports/1"}, 504)
+	e.Add(RequestInfo{Method: "GET", Path: "/unknown"}, 200)
+
+	report := e.Report(2)
+	if report.Requests != 6 || report.Endpoints != 3 {
+		t.Errorf("requests %d, endpoints %d; want 6, 3", report.Requests, report.Endpoints)
+	}
+
+	// {GET user 200} {GET user 404} {DELETE user 500} {GET report 504} {GET other 200}
+	if m := metricCardinality(report, "rr_http_requests_by_endpoint_total"); m.LabelSets != 5 || m.Series != 5 {
+		t.Errorf("requests_by_endpoint = %+v, want 5 label sets", m)
+	}
+	// {GET user} {DELETE user} {GET other} with 2 buckets, {GET report} with 3 buckets
+	if m := metricCardinality(report, "rr_http_duration_by_endpoint_seconds"); m.LabelSets != 4 || m.Series != 3*5+6 {
+		t.Errorf("duration_by_endpoint = %+v, want 4 label sets and 21 series", m)
+	}
+	// client_error, server_error and timeout
+	if m := metricCardinality(report, "rr_http_errors_total"); m.LabelSets != 3 {
+		t.Errorf("errors_total = %+v, want 3 label sets", m)
+	}
+	if report.TotalSeries() != 5+21+3 {
+		t.Errorf("total series = %d, want 29", report.TotalSeries())
+	}
+
+	want := []EndpointCount{{"user", 4}, {"other", 1}}
+	if len(report.TopEndpoints) != 2 || report.TopEndpoints[0] != want[0] || report.TopEndpoints[1] != want[1] {
+		t.Errorf("top endpoints = %v, want %v", report.TopEndpoints, want)
+	}
+	if report.OtherShare != 1.0/6 {
+		t.Errorf("other share = %v, want 1/6", report.OtherShare)
+	}
+}
+
+func TestCardinalityEstimatorOverflowAndExclude(t *testing.T) {
+	e := newTestEstimator(t, func(cfg *Config) {
+		cfg.EndpointPatterns.MaxPatterns = 1
+		cfg.EndpointPatterns.Rules = []PatternRule{
+			{Route: "/a", Name: "a"},
+			{Route: "/b", Name: "b"},
+			{Route: "/health", Name: "health"},
+		}
+		cfg.Exclude.Endpoints = []string{"health"}
+		cfg.Exclude.Methods = []string{"OPTIONS"}
+	})
+
+	e.Add(RequestInfo{Method: "GET", Path: "/a"}, 200)
+	e.Add(RequestInfo{Method: "GET", Path: "/b"}, 200)
+	e.Add(RequestInfo{Method: "GET", Path: "/b"}, 200)
+	e.Add(RequestInfo{Method: "GET", Path: "/other"}, 200)
+	e.Add(RequestInfo{Method: "GET", Path: "/health"}, 200)
+	e.Add(RequestInfo{Method: "OPTIONS", Path: "/a"}, 204)
+
+	report := e.Report(-1)
+	if report.Requests != 4 || report.Excluded != 2 {
+		t.Errorf("requests %d, excluded %d; want 4, 2", report.Requests, report.Excluded)
+	}
+	if report.OverflowShare != 0.5 || report.OtherShare != 0.25 {
+		t.Errorf("overflow share %v, other share %v; want 0.5, 0.25", report.OverflowShare, report.OtherShare)
+	}
+	if len(report.TopEndpoints) != 3 {
+		t.Errorf("top endpoints = %v, want all 3", report.TopEndpoints)
+	}
+}
+
+func TestCardinalityEstimatorLabels(t *testing.T) {
+	e := newTestEstimator(t, func(cfg *Config) {
+		cfg.GroupLabel = false
+		cfg.EndpointPatterns.Rules = []PatternRule{{Route: "/users/{id:int}", Name: "user"}}
+	})
+	e.Add(RequestInfo{Method: "GET", Host: "a.example.com", Path: "/users/1"}, 200)
+	e.Add(RequestInfo{Method: "GET", Host: "b.example.com", Path: "/users/1"}, 200)
+
+	// without the host label both hosts share a label set
+	if m := metricCardinality(e.Report(0), "rr_http_requests_by_endpoint_total"); m.LabelSets != 1 {
+		t.Errorf("requests_by_endpoint = %+v, want 1 label set", m)
+	}
+	if report := e.Report(0); len(report.TopEndpoints) != 0 {
+		t.Errorf("top endpoints = %v, want none", report.TopEndpoints)
+	}
+
+	e = newTestEstimator(t, func(cfg *Config) {
+		cfg.HostLabel = HostLabelConfig{Enabled: true, Hosts: []string{"a.example.com"}}
+		cfg.EndpointPatterns.Rules = []PatternRule{{Route: "/users/{id:int}", Name: "user"}}
+	})
+	for _, host := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		e.Add(RequestInfo{Method: "GET", Host: host, Path: "/users/1"}, 500)
+	}
+
+	// hosts outside the allowlist collapse into "other"
+	report := e.Report(0)
+	for _, name := range []string{"rr_http_requests_by_endpoint_total", "rr_http_duration_by_endpoint_seconds", "rr_http_errors_total"} {
+		if m := metricCardinality(report, name); m.LabelSets != 2 {
+			t.Errorf("%s = %+v, want 2 label sets", name, m)
+		}
+	}
+}
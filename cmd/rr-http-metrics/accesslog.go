@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	httpmetrics "github.com/roadrunner-plugins/http-metrics/v5"
+)
+
+// combinedLog matches the nginx/apache combined (and common) log format:
+// $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent ...
+var combinedLog = regexp.MustCompile(`^\S+ \S+ \S+ \[[^\]]*\] "(\S+) (\S+)[^"]*" (\d{3}) `)
+
+// errSkipLine marks log lines that are not requests, e.g. malformed ones
+var errSkipLine = errors.New("not a request line")
+
+// jsonFields names the keys of JSON access log entries
+type jsonFields struct {
+	method string
+	path   string
+	status string
+	host   string
+}
+
+// parseLogLine parses a combined format or JSON access log line.
+// The format is detected per line: JSON entries start with '{'
+func parseLogLine(line string, fields jsonFields) (httpmetrics.RequestInfo, int, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return httpmetrics.RequestInfo{}, 0, errSkipLine
+	}
+
+	if strings.HasPrefix(line, "{") {
+		return parseJSONLine(line, fields)
+	}
+
+	m := combinedLog.FindStringSubmatch(line + " ")
+	if m == nil {
+		return httpmetrics.RequestInfo{}, 0, errSkipLine
+	}
+
+	status, _ := strconv.Atoi(m[3])
+	host, path := splitTarget(m[2])
+	return httpmetrics.RequestInfo{Method: m[1], Host: host, Path: path}, status, nil
+}
+
+func parseJSONLine(line string, fields jsonFields) (httpmetrics.RequestInfo, int, error) {
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return httpmetrics.RequestInfo{}, 0, err
+	}
+
+	req := httpmetrics.RequestInfo{
+		Method: stringField(entry, fields.method),
+		Host:   httpmetrics.NormalizeHost(stringField(entry, fields.host)),
+	}
+
+	target := stringField(entry, fields.path)
+	if target == "" {
+		// nginx "$request" style field: "GET /path HTTP/1.1"
+		parts := strings.Fields(stringField(entry, "request"))
+		if len(parts) >= 2 {
+			req.Method, target = parts[0], parts[1]
+		}
+	}
+	if target == "" {
+		return req, 0, errSkipLine
+	}
+
+	host, path := splitTarget(target)
+	if req.Host == "" {
+		req.Host = host
+	}
+	req.Path = path
+
+	status, err := strconv.Atoi(stringField(entry, fields.status))
+	if err != nil {
+		return req, 0, fmt.Errorf("invalid %s field: %w", fields.status, err)
+	}
+
+	return req, status, nil
+}
+
+// stringField returns the value of a JSON field as string, numbers included
+func stringField(entry map[string]any, key string) string {
+	switch v := entry[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// splitTarget splits a request target into host (for absolute URLs) and path without query
+func splitTarget(target string) (string, string) {
+	if u, err := url.Parse(target); err == nil {
+		return strings.ToLower(u.Hostname()), u.Path
+	}
+
+	path, _, _ := strings.Cut(target, "?")
+	return "", path
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	httpmetrics "github.com/roadrunner-plugins/http-metrics/v5"
+)
+
+var defaultJSONFields = jsonFields{method: "method", path: "uri", status: "status", host: "host"}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		req    httpmetrics.RequestInfo
+		status int
+	}{
+		{
+			"combined",
+			`10.0.0.1 - - [10/Oct/2024:13:55:36 +0000] "GET /users/1?page=2 HTTP/1.1" 200 512 "-" "curl/8.0"`,
+			httpmetrics.RequestInfo{Method: "GET", Path: "/users/1"}, 200,
+		},
+		{
+			"common without trailing fields",
+			`10.0.0.1 - frank [10/Oct/2024:13:55:36 +0000] "POST /orders HTTP/1.1" 201 0`,
+			httpmetrics.RequestInfo{Method: "POST", Path: "/orders"}, 201,
+		},
+		{
+			"combined absolute target",
+			`10.0.0.1 - - [10/Oct/2024:13:55:36 +0000] "GET http://API.example.com/users HTTP/1.1" 502 0 "-" "-"`,
+			httpmetrics.RequestInfo{Method: "GET", Host: "api.example.com", Path: "/users"}, 502,
+		},
+		{
+			"json",
+			`{"method": "DELETE", "uri": "/users/1?force=1", "status": 204, "host": "API.example.com"}`,
+			httpmetrics.RequestInfo{Method: "DELETE", Host: "api.example.com", Path: "/users/1"}, 204,
+		},
+		{
+			// nginx $http_host keeps the port of the Host header
+			"json host with port",
+			`{"method": "GET", "uri": "/users", "status": 200, "host": "Example.com:443"}`,
+			httpmetrics.RequestInfo{Method: "GET", Host: "example.com", Path: "/users"}, 200,
+		},
+		{
+			"json request field",
+			`{"request": "PUT /users/1 HTTP/2.0", "status": "200"}`,
+			httpmetrics.RequestInfo{Method: "PUT", Path: "/users/1"}, 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, status, err := parseLogLine(tt.line, defaultJSONFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req != tt.req || status != tt.status {
+				t.Errorf("parseLogLine = %+v %d, want %+v %d", req, status, tt.req, tt.status)
+			}
+		})
+	}
+}
+
+func TestParseLogLineCustomFields(t *testing.T) {
+	fields := jsonFields{method: "verb", path: "path", status: "code", host: "vhost"}
+	req, status, err := parseLogLine(`{"verb": "GET", "path": "/a", "code": 404, "vhost": "b.example.com"}`, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := httpmetrics.RequestInfo{Method: "GET", Host: "b.example.com", Path: "/a"}
+	if req != want || status != 404 {
+		t.Errorf("parseLogLine = %+v %d, want %+v 404", req, status, want)
+	}
+}
+
+func TestParseLogLineSkipped(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"   ",
+		"not a log line",
+		`10.0.0.1 - - [10/Oct/2024:13:55:36 +0000] "-" 400 0 "-" "-"`,
+		`{"method": "GET", "uri": "/a", "status": "ok"}`,
+		`{"method": "GET", "status": 200}`,
+		`{"method": "GET", "uri": "/a"`,
+	} {
+		if req, status, err := parseLogLine(line, defaultJSONFields); err == nil {
+			t.Errorf("parseLogLine(%q) = %+v %d, want an error", line, req, status)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-viper/mapstructure/v2"
+	httpmetrics "github.com/roadrunner-plugins/http-metrics/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// configKey is the RoadRunner configuration section of the plugin
+const configKey = "http_metrics"
+
+// loadConfig reads the http_metrics section of a RoadRunner configuration
+// file on top of the plugin defaults, the same way RoadRunner does
+func loadConfig(path string) (*httpmetrics.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	section, ok := raw[configKey]
+	if !ok {
+		return nil, fmt.Errorf("%s: no %s section", path, configKey)
+	}
+
+	cfg := httpmetrics.DefaultConfig()
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           cfg,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = dec.Decode(section); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", path, configKey, err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,300 @@
+// Command rr-http-metrics checks http_metrics endpoint rules before they are deployed.
+//
+// Usage:
+//
+//	rr-http-metrics lint -config .rr.yaml
+//	rr-http-metrics test -config .rr.yaml -paths samples.txt
+//	rr-http-metrics estimate -config .rr.yaml -log access.log -max-series 5000
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	httpmetrics "github.com/roadrunner-plugins/http-metrics/v5"
+)
+
+const usage = `Usage: rr-http-metrics <command> [flags]
+
+Commands:
+  lint       report invalid, shadowed and duplicate endpoint rules
+  test       show which rule and label each sample path produces
+  estimate   replay access logs and estimate the series endpoint metrics produce
+
+Run "rr-http-metrics <command> -h" for command flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "lint":
+		err = runLint(os.Args[2:], os.Stdout)
+	case "test":
+		err = runTest(os.Args[2:], os.Stdout)
+	case "estimate":
+		err = runEstimate(os.Args[2:], os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// loadRules reads the configuration and returns the inline and rules file rules
+func loadRules(path string) (*httpmetrics.Config, []httpmetrics.PatternRule, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rules, err := cfg.EndpointPatterns.LoadRules()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, rules, nil
+}
+
+func runLint(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configPath := fs.String("config", ".rr.yaml", "RoadRunner configuration file")
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	_ = fs.Parse(args)
+
+	_, rules, err := loadRules(*configPath)
+	if err != nil {
+		return err
+	}
+
+	issues := httpmetrics.LintRules(rules)
+	failed := false
+	for _, issue := range issues {
+		fmt.Fprintf(out, "%s\n    %s\n", issue, describeRule(rules[issue.Rule]))
+		if issue.Severity == httpmetrics.LintError || *strict {
+			failed = true
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("%d issue(s) found in %d rule(s)", len(issues), len(rules))
+	}
+
+	fmt.Fprintf(out, "%d rule(s) checked, %d warning(s)\n", len(rules), len(issues))
+	return nil
+}
+
+func runTest(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	configPath := fs.String("config", ".rr.yaml", "RoadRunner configuration file")
+	pathsFile := fs.String("paths", "", `file with one sample per line: "/path", "METHOD /path" or "METHOD host /path" (default: stdin)`)
+	_ = fs.Parse(args)
+
+	cfg, rules, err := loadRules(*configPath)
+	if err != nil {
+		return err
+	}
+
+	// dry-run the rules even if endpoint patterns are switched off in the config
+	patterns := cfg.EndpointPatterns
+	patterns.Enabled = true
+	patterns.Rules = rules
+	patterns.RulesFile = ""
+
+	matcher, err := httpmetrics.NewEndpointMatcher(patterns)
+	if err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if *pathsFile != "" {
+		f, err := os.Open(*pathsFile)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tHOST\tPATH\tRULE\tLABEL\tGROUP")
+
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		req, ok, err := parseSample(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if !ok {
+			continue
+		}
+
+		match := matcher.Explain(req)
+		rule := "-"
+		if match.Rule >= 0 {
+			rule = fmt.Sprintf("#%d %s", match.Rule, describeRule(rules[match.Rule]))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", req.Method, req.Host, req.Path, rule, match.Label, match.Group)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	return tw.Flush()
+}
+
+func runEstimate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+	configPath := fs.String("config", ".rr.yaml", "RoadRunner configuration file")
+	logFile := fs.String("log", "", "access log in nginx combined or JSON lines format (default: stdin)")
+	top := fs.Int("top", 20, "number of most frequent endpoints to show")
+	maxSeries := fs.Int("max-series", 0, "fail if the estimated series exceed this number (0 = no limit)")
+	maxOther := fs.Float64("max-other", 0, `fail if the share of "other" traffic exceeds this ratio, 0-1 (0 = no limit)`)
+	fields := jsonFields{}
+	fs.StringVar(&fields.method, "json-method", "method", "JSON log field with the request method")
+	fs.StringVar(&fields.path, "json-path", "uri", "JSON log field with the request URI")
+	fs.StringVar(&fields.status, "json-status", "status", "JSON log field with the response status")
+	fs.StringVar(&fields.host, "json-host", "host", "JSON log field with the request host")
+	_ = fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	estimator, err := httpmetrics.NewCardinalityEstimator(cfg)
+	if err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if *logFile != "" {
+		f, err := os.Open(*logFile)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+
+	skipped := 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		req, status, err := parseLogLine(scanner.Text(), fields)
+		if err != nil {
+			skipped++
+			continue
+		}
+		estimator.Add(req, status)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	report := estimator.Report(*top)
+
+	fmt.Fprintf(out, "requests: %d (skipped lines: %d)\n", report.Requests, skipped)
+	if report.Excluded > 0 {
+		fmt.Fprintf(out, "excluded: %d\n", report.Excluded)
+	}
+	fmt.Fprintf(out, "distinct endpoints: %d\n", report.Endpoints)
+	fmt.Fprintf(out, "other: %.2f%%\n", report.OtherShare*100)
+	if report.OverflowShare > 0 {
+		fmt.Fprintf(out, "overflow: %.2f%%\n", report.OverflowShare*100)
+	}
+	fmt.Fprintln(out)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tLABEL SETS\tSERIES")
+	for _, m := range report.Metrics {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", m.Name, m.LabelSets, m.Series)
+	}
+	fmt.Fprintf(tw, "total\t\t%d\n", report.TotalSeries())
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "ENDPOINT\tREQUESTS\tSHARE")
+	for _, e := range report.TopEndpoints {
+		share := 0.0
+		if report.Requests > 0 {
+			share = float64(e.Requests) / float64(report.Requests) * 100
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.2f%%\n", e.Endpoint, e.Requests, share)
+	}
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+
+	if *maxSeries > 0 && report.TotalSeries() > *maxSeries {
+		return fmt.Errorf("estimated %d series exceed the limit of %d", report.TotalSeries(), *maxSeries)
+	}
+	if *maxOther > 0 && report.OtherShare > *maxOther {
+		return fmt.Errorf(`"other" share %.2f%% exceeds the limit of %.2f%%`, report.OtherShare*100, *maxOther*100)
+	}
+
+	return nil
+}
+
+// parseSample parses a sample line. Empty lines and # comments are skipped
+func parseSample(line string) (httpmetrics.RequestInfo, bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return httpmetrics.RequestInfo{}, false, nil
+	}
+
+	req := httpmetrics.RequestInfo{Method: "GET"}
+	switch len(fields) {
+	case 1:
+		req.Path = fields[0]
+	case 2:
+		req.Method, req.Path = strings.ToUpper(fields[0]), fields[1]
+	case 3:
+		req.Method, req.Host, req.Path = strings.ToUpper(fields[0]), httpmetrics.NormalizeHost(fields[1]), fields[2]
+	default:
+		return req, false, fmt.Errorf("expected [METHOD [HOST]] PATH, got %q", line)
+	}
+
+	if path, _, ok := strings.Cut(req.Path, "?"); ok {
+		req.Path = path
+	}
+
+	return req, true, nil
+}
+
+// describeRule returns a short human readable form of a rule
+func describeRule(rule httpmetrics.PatternRule) string {
+	var sb strings.Builder
+	if rule.Route != "" {
+		fmt.Fprintf(&sb, "route=%q", rule.Route)
+	} else {
+		fmt.Fprintf(&sb, "pattern=%q", rule.Pattern)
+	}
+	if rule.Name != "" {
+		fmt.Fprintf(&sb, " name=%q", rule.Name)
+	}
+	if len(rule.Methods) > 0 {
+		fmt.Fprintf(&sb, " methods=%s", strings.Join(rule.Methods, ","))
+	}
+	if rule.Host != "" {
+		fmt.Fprintf(&sb, " host=%s", rule.Host)
+	}
+	if rule.Group != "" {
+		fmt.Fprintf(&sb, " group=%s", rule.Group)
+	}
+	return sb.String()
+}
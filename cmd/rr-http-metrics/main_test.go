@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	httpmetrics "github.com/roadrunner-plugins/http-metrics/v5"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), ".rr.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseSample(t *testing.T) {
+	tests := []struct {
+		line string
+		want httpmetrics.RequestInfo
+		ok   bool
+	}{
+		{"/users/1", httpmetrics.RequestInfo{Method: "GET", Path: "/users/1"}, true},
+		{"post /users?page=2", httpmetrics.RequestInfo{Method: "POST", Path: "/users"}, true},
+		{"put API.Example.com /users/1", httpmetrics.RequestInfo{Method: "PUT", Host: "api.example.com", Path: "/users/1"}, true},
+		{"GET api.example.com:8443 /users", httpmetrics.RequestInfo{Method: "GET", Host: "api.example.com", Path: "/users"}, true},
+		{"", httpmetrics.RequestInfo{}, false},
+		{"   ", httpmetrics.RequestInfo{}, false},
+		{"# GET /users", httpmetrics.RequestInfo{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok, err := parseSample(tt.line)
+		if err != nil {
+			t.Errorf("parseSample(%q): %v", tt.line, err)
+			continue
+		}
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseSample(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if _, _, err := parseSample("GET host /path extra"); err == nil {
+		t.Error("sample with four fields accepted")
+	}
+}
+
+func TestDescribeRule(t *testing.T) {
+	tests := map[string]httpmetrics.PatternRule{
+		`route="/users/{id}"`: {Route: "/users/{id}"},
+		`pattern="^/admin" name="admin" methods=GET,POST host=*.example.com group=internal`: {
+			Pattern: "^/admin", Name: "admin", Methods: []string{"GET", "POST"}, Host: "*.example.com", Group: "internal",
+		},
+	}
+	for want, rule := range tests {
+		if got := describeRule(rule); got != want {
+			t.Errorf("describeRule(%+v) = %s, want %s", rule, got, want)
+		}
+	}
+}
+
+func TestRunLint(t *testing.T) {
+	clean := writeConfig(t, `
+http_metrics:
+  endpoint_patterns:
+    rules:
+      - route: /users/{id:int}
+        name: user
+`)
+	var out bytes.Buffer
+	if err := runLint([]string{"-config", clean}, &out); err != nil {
+		t.Fatalf("clean rules: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "1 rule(s) checked, 0 warning(s)") {
+		t.Errorf("output = %q", out.String())
+	}
+
+	warnings := writeConfig(t, `
+http_metrics:
+  endpoint_patterns:
+    rules:
+      - pattern: ^/users/.*
+        name: users
+      - route: /users/{id:int}
+        name: users
+`)
+	out.Reset()
+	if err := runLint([]string{"-config", warnings}, &out); err != nil {
+		t.Errorf("warnings failed without -strict: %v", err)
+	}
+	if !strings.Contains(out.String(), "rule #1") {
+		t.Errorf("output = %q, want issues of rule #1", out.String())
+	}
+	if err := runLint([]string{"-config", warnings, "-strict"}, &out); err == nil {
+		t.Error("warnings passed with -strict")
+	}
+
+	invalid := writeConfig(t, `
+http_metrics:
+  endpoint_patterns:
+    rules:
+      - route: /users/{id:float}
+`)
+	if err := runLint([]string{"-config", invalid}, &out); err == nil {
+		t.Error("invalid rule passed")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+http_metrics:
+  collect_sizes: false
+  endpoint_patterns:
+    max_patterns: "50"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CollectSizes || cfg.EndpointPatterns.MaxPatterns != 50 {
+		t.Errorf("config = %+v", cfg)
+	}
+	// unset keys keep the plugin defaults
+	if !cfg.EndpointPatterns.Enabled {
+		t.Error("endpoint_patterns.enabled default lost")
+	}
+
+	if _, err = loadConfig(writeConfig(t, "http:\n  address: :8080\n")); err == nil {
+		t.Error("config without http_metrics accepted")
+	}
+}
+
+func TestRunEstimate(t *testing.T) {
+	config := writeConfig(t, `
+http_metrics:
+  endpoint_patterns:
+    rules:
+      - route: /users/{id:int}
+        name: user
+`)
+	log := filepath.Join(t.TempDir(), "access.log")
+	lines := `10.0.0.1 - - [10/Oct/2024:13:55:36 +0000] "GET /users/1 HTTP/1.1" 200 512 "-" "-"
+{"method": "GET", "uri": "/users/2", "status": 500}
+{"method": "GET", "uri": "/unknown", "status": 200}
+garbage
+`
+	if err := os.WriteFile(log, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runEstimate([]string{"-config", config, "-log", log}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"requests: 3 (skipped lines: 1)", "distinct endpoints: 2", "other: 33.33%"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+
+	if err := runEstimate([]string{"-config", config, "-log", log, "-max-other", "0.25"}, &out); err == nil {
+		t.Error("other share above -max-other passed")
+	}
+	if err := runEstimate([]string{"-config", config, "-log", log, "-max-series", "1"}, &out); err == nil {
+		t.Error("series above -max-series passed")
+	}
+}
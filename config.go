@@ -10,20 +10,84 @@ type Config struct {
 	// EndpointPatterns configures endpoint pattern matching and grouping
 	EndpointPatterns EndpointPatternsConfig `mapstructure:"endpoint_patterns"`
 
+	// RouteHeader lets the worker name the endpoint through a response header
+	RouteHeader RouteHeaderConfig `mapstructure:"route_header"`
+
+	// HostLabel adds a host label to the endpoint-level metrics
+	HostLabel HostLabelConfig `mapstructure:"host_label"`
+
+	// GroupLabel adds the endpoint group label to the endpoint-level metrics (default: false).
+	// Enabling it changes the label set of existing series
+	GroupLabel bool `mapstructure:"group_label"`
+
+	// Exclude leaves matching requests out of metrics collection
+	Exclude ExcludeConfig `mapstructure:"exclude"`
+
 	// CollectSizes enables request/response body size tracking
 	CollectSizes bool `mapstructure:"collect_sizes"`
 
+	// CollectDeclaredSize additionally records the Content-Length clients declare,
+	// next to the bytes actually read from the body
+	CollectDeclaredSize bool `mapstructure:"collect_declared_size"`
+
 	// CollectQueueTime enables queue time vs processing time breakdown
 	CollectQueueTime bool `mapstructure:"collect_queue_time"`
 
+	// UpstreamQueueTime measures time spent in front of RoadRunner from proxy headers
+	UpstreamQueueTime UpstreamQueueTimeConfig `mapstructure:"upstream_queue_time"`
+
+	// CollectTTFB enables the time to first byte histogram
+	CollectTTFB bool `mapstructure:"collect_ttfb"`
+
+	// CollectAborts counts requests the client went away from before the handler returned
+	CollectAborts bool `mapstructure:"collect_aborts"`
+
+	// Streaming records flushes and lifetime of streamed responses such as Server-Sent Events
+	Streaming StreamingConfig `mapstructure:"streaming"`
+
+	// WorkerStartHeader is the response header the worker sets to the unix time it
+	// started processing the request (default: X-RR-Worker-Start). It splits the
+	// request duration into queue and processing time; requests without it have no
+	// queue time and their whole duration is processing time. The header is removed
+	// from the response
+	WorkerStartHeader string `mapstructure:"worker_start_header"`
+
 	// CollectWorkerInfo enables worker pool health metrics
 	CollectWorkerInfo bool `mapstructure:"collect_worker_info"`
 
+	// WorkerInfoInterval defines how often worker pool metrics are refreshed (default: 5s)
+	WorkerInfoInterval time.Duration `mapstructure:"worker_info_interval"`
+
+	// MaxWorkerSeries caps the number of workers exported with per-PID gauges (default: 64).
+	// Zero disables the per-worker gauges
+	MaxWorkerSeries int `mapstructure:"max_worker_series"`
+
 	// DurationBuckets defines histogram buckets for duration metrics (in seconds)
 	DurationBuckets []float64 `mapstructure:"duration_buckets"`
 
 	// SizeBuckets defines histogram buckets for size metrics (in bytes)
 	SizeBuckets []float64 `mapstructure:"size_buckets"`
+
+	// QueueTimeBuckets defines histogram buckets for queue time (in seconds).
+	// Empty means DurationBuckets
+	QueueTimeBuckets []float64 `mapstructure:"queue_time_buckets"`
+
+	// ProcessingTimeBuckets defines the default histogram buckets for processing time
+	// (in seconds). Empty means DurationBuckets
+	ProcessingTimeBuckets []float64 `mapstructure:"processing_time_buckets"`
+
+	// UpgradedConnectionBuckets defines histogram buckets for the lifetime of
+	// hijacked connections such as WebSockets (in seconds)
+	UpgradedConnectionBuckets []float64 `mapstructure:"upgraded_connection_buckets"`
+
+	// BucketProfiles defines named bucket sets (in seconds) endpoint rules can refer to.
+	// A profile replaces the default buckets of duration_by_endpoint_seconds,
+	// processing_time_seconds and time_to_first_byte_seconds for the endpoints of the rule
+	BucketProfiles map[string][]float64 `mapstructure:"bucket_profiles"`
+
+	// GroupBuckets assigns bucket profiles to endpoint groups.
+	// The buckets of a rule take precedence over the ones of its group
+	GroupBuckets map[string]string `mapstructure:"group_buckets"`
 }
 
 // EndpointPatternsConfig configures endpoint pattern matching
@@ -31,23 +95,177 @@ type EndpointPatternsConfig struct {
 	// Enabled controls whether endpoint pattern matching is active
 	Enabled bool `mapstructure:"enabled"`
 
-	// MaxPatterns limits the number of unique endpoint patterns to prevent cardinality explosion
+	// MaxPatterns limits the number of unique endpoint patterns to prevent cardinality explosion.
+	// Zero or a negative value disables the limit
 	MaxPatterns int `mapstructure:"max_patterns"`
 
-	// Rules defines regex patterns for grouping similar endpoints
+	// OverflowLabel is the endpoint label used once MaxPatterns is reached
+	OverflowLabel string `mapstructure:"overflow_label"`
+
+	// DefaultGroup is the group of endpoints whose rule has no group, of
+	// unmatched paths and of route names reported by the worker (default: "other")
+	DefaultGroup string `mapstructure:"default_group"`
+
+	// Rules defines regex patterns or route templates for grouping similar endpoints.
+	// Route templates are matched first (most specific segment wins), regex
+	// rules are tried afterwards in the order they are listed
 	Rules []PatternRule `mapstructure:"rules"`
 
 	// CacheSize defines the LRU cache size for pattern matching results
 	CacheSize int `mapstructure:"cache_size"`
+
+	// CacheMaxKeyLength skips caching for longer paths (including method and host
+	// for method or host scoped rules) to bound cache memory (default: 1024).
+	// Zero or a negative value disables the limit
+	CacheMaxKeyLength int `mapstructure:"cache_max_key_length"`
+
+	// RulesFile is an optional YAML or JSON file with additional rules under a "rules" key.
+	// Its rules are appended to Rules and reloaded when the file changes or via RPC
+	RulesFile string `mapstructure:"rules_file"`
+
+	// ReloadInterval defines how often RulesFile is checked for changes (default: 5s)
+	ReloadInterval time.Duration `mapstructure:"reload_interval"`
+
+	// AutoNormalize replaces ID-like segments of paths matching no rule
+	AutoNormalize AutoNormalizeConfig `mapstructure:"auto_normalize"`
+}
+
+// AutoNormalizeConfig configures automatic normalization of unmatched paths
+type AutoNormalizeConfig struct {
+	// Enabled turns normalization on; when off unmatched paths are labelled "other"
+	Enabled bool `mapstructure:"enabled"`
+
+	// Detectors lists the enabled detectors: num, uuid, hex, base64, date, email.
+	// Empty means all of them
+	Detectors []string `mapstructure:"detectors"`
+
+	// MaxDepth limits the number of path segments kept, deeper ones collapse into "...".
+	// Zero disables the limit
+	MaxDepth int `mapstructure:"max_depth"`
+}
+
+// RouteHeaderConfig configures endpoint names reported by the worker.
+// The header is always removed from the response sent to the client
+type RouteHeaderConfig struct {
+	// Enabled turns the route header on. Matcher rules remain the fallback
+	// when the header is missing or rejected
+	Enabled bool `mapstructure:"enabled"`
+
+	// Name is the response header carrying the route name (default: X-RR-Route)
+	Name string `mapstructure:"name"`
+
+	// Allow lists regex patterns an accepted route name must match. Empty accepts any name
+	Allow []string `mapstructure:"allow"`
+
+	// MaxLength rejects longer route names (default: 128)
+	MaxLength int `mapstructure:"max_length"`
+}
+
+// HostLabelConfig configures the optional host label
+type HostLabelConfig struct {
+	// Enabled adds the host label to requests_by_endpoint_total,
+	// duration_by_endpoint_seconds and errors_total
+	Enabled bool `mapstructure:"enabled"`
+
+	// Hosts is an allowlist of host label values, other hosts are reported as "other".
+	// Empty means every host is reported as is, which lets clients drive cardinality
+	Hosts []string `mapstructure:"hosts"`
+}
+
+// UpstreamQueueTimeConfig configures the upstream queue time histogram. Proxies
+// stamp requests with the time they received them, e.g. X-Request-Start: t=<unix micros>
+type UpstreamQueueTimeConfig struct {
+	// Enabled turns rr_http_upstream_queue_time_seconds on
+	Enabled bool `mapstructure:"enabled"`
+
+	// Headers lists request headers checked in order (default: X-Request-Start, X-Queue-Start)
+	Headers []string `mapstructure:"headers"`
+
+	// TrustedProxies lists CIDRs of the direct peers whose headers are trusted.
+	// Headers from other peers are ignored, so empty means none are used
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+
+	// MaxSkew is the tolerated clock skew between proxy and RoadRunner (default: 1s).
+	// Timestamps up to MaxSkew in the future count as no queueing, later ones are dropped
+	MaxSkew time.Duration `mapstructure:"max_skew"`
+
+	// MaxAge drops timestamps older than this, e.g. from misconfigured proxies (default: 5m)
+	MaxAge time.Duration `mapstructure:"max_age"`
+}
+
+// StreamingConfig configures metrics for streamed responses. A response is
+// streamed when it has one of ContentTypes or is flushed more than once
+type StreamingConfig struct {
+	// Enabled turns stream detection and the rr_http_stream_* metrics on
+	Enabled bool `mapstructure:"enabled"`
+
+	// ContentTypes lists media types that are always streams (default: text/event-stream)
+	ContentTypes []string `mapstructure:"content_types"`
+
+	// ExcludeFromDuration leaves streams out of request_duration_seconds,
+	// duration_by_endpoint_seconds and processing_time_seconds. Their
+	// queue_time_seconds is still recorded
+	ExcludeFromDuration bool `mapstructure:"exclude_from_duration"`
+
+	// LifetimeBuckets defines histogram buckets for the stream lifetime (in seconds)
+	LifetimeBuckets []float64 `mapstructure:"lifetime_buckets"`
 }
 
-// PatternRule defines a single pattern matching rule
+// ExcludeConfig configures requests that are not recorded, e.g. health checks
+// and load balancer probes. A request is excluded if any of the lists matches.
+// Excluded requests are still served as usual
+type ExcludeConfig struct {
+	// Paths lists regex patterns matched against the request path
+	Paths []string `mapstructure:"paths"`
+
+	// Endpoints lists endpoint labels produced by endpoint_patterns rules.
+	// Route names reported through route_header are not known before the request is served
+	Endpoints []string `mapstructure:"endpoints"`
+
+	// Methods lists HTTP methods, e.g. OPTIONS
+	Methods []string `mapstructure:"methods"`
+
+	// UserAgents lists regex patterns matched against the User-Agent header
+	UserAgents []string `mapstructure:"user_agents"`
+
+	// CIDRs lists source address ranges of the direct peer, e.g. 10.0.0.0/8
+	CIDRs []string `mapstructure:"cidrs"`
+
+	// Count counts excluded requests in rr_http_excluded_requests_total
+	Count bool `mapstructure:"count"`
+}
+
+// PatternRule defines a single pattern matching rule.
+// Exactly one of Pattern or Route must be set.
 type PatternRule struct {
 	// Pattern is the regex pattern to match against request paths
 	Pattern string `mapstructure:"pattern"`
 
-	// Name is the label value to use when pattern matches
+	// Route is a route template such as /api/users/{id:int} or /static/*file.
+	// Supported placeholder types: int, uuid, hex, alpha, alnum, slug (untyped matches any segment)
+	Route string `mapstructure:"route"`
+
+	// Name is the label value to use when pattern matches.
+	// Defaults to the route template for route rules.
+	// May reference capture groups or route placeholders: $1, $name, ${name};
+	// ${name:norm} inserts auto-normalization placeholders (e.g. :num) instead
+	// of the captured value. $$ is a literal dollar sign
 	Name string `mapstructure:"name"`
+
+	// Methods restricts the rule to the listed HTTP methods. Empty means any method
+	Methods []string `mapstructure:"methods"`
+
+	// Host restricts the rule to a virtual host, either exact (api.example.com)
+	// or a subdomain wildcard (*.example.com). Empty means any host
+	Host string `mapstructure:"host"`
+
+	// Group is the area the endpoints of this rule belong to, e.g. "public-api" or "admin".
+	// Empty means DefaultGroup
+	Group string `mapstructure:"group"`
+
+	// Buckets names a bucket profile from bucket_profiles used for the endpoints
+	// of this rule. Empty means the default buckets
+	Buckets string `mapstructure:"buckets"`
 }
 
 // DefaultConfig returns the default configuration
@@ -55,14 +273,44 @@ func DefaultConfig() *Config {
 	return &Config{
 		Enabled: true,
 		EndpointPatterns: EndpointPatternsConfig{
-			Enabled:     true,
-			MaxPatterns: 100,
-			Rules:       []PatternRule{},
-			CacheSize:   10000,
+			Enabled:           true,
+			MaxPatterns:       100,
+			OverflowLabel:     "__overflow__",
+			DefaultGroup:      "other",
+			Rules:             []PatternRule{},
+			CacheSize:         10000,
+			CacheMaxKeyLength: 1024,
+			ReloadInterval:    5 * time.Second,
+			AutoNormalize: AutoNormalizeConfig{
+				Enabled:  false,
+				MaxDepth: 6,
+			},
 		},
-		CollectSizes:      true,
-		CollectQueueTime:  true,
-		CollectWorkerInfo: false, // Disabled by default as it requires HTTP plugin integration
+		RouteHeader: RouteHeaderConfig{
+			Enabled:   false,
+			Name:      "X-RR-Route",
+			MaxLength: 128,
+		},
+		GroupLabel:       false,
+		CollectSizes:     true,
+		CollectQueueTime: true,
+		CollectTTFB:      true,
+		CollectAborts:    true,
+		Streaming: StreamingConfig{
+			Enabled:         true,
+			ContentTypes:    []string{"text/event-stream"},
+			LifetimeBuckets: []float64{1, 10, 60, 300, 900, 1800, 3600, 7200},
+		},
+		WorkerStartHeader: "X-RR-Worker-Start",
+		UpstreamQueueTime: UpstreamQueueTimeConfig{
+			Enabled: false,
+			Headers: []string{"X-Request-Start", "X-Queue-Start"},
+			MaxSkew: time.Second,
+			MaxAge:  5 * time.Minute,
+		},
+		CollectWorkerInfo:  false, // Disabled by default as it requires HTTP plugin integration
+		WorkerInfoInterval: 5 * time.Second,
+		MaxWorkerSeries:    64,
 		DurationBuckets: []float64{
 			0.001, // 1ms
 			0.005, // 5ms
@@ -81,6 +329,16 @@ func DefaultConfig() *Config {
 			1048576,  // 1MB
 			10485760, // 10MB
 		},
+		UpgradedConnectionBuckets: []float64{
+			1,    // 1s
+			10,   // 10s
+			60,   // 1m
+			300,  // 5m
+			900,  // 15m
+			1800, // 30m
+			3600, // 1h
+			7200, // 2h
+		},
 	}
 }
 
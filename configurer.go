@@ -1,5 +1,9 @@
 package prometheus
 
+import (
+	"github.com/roadrunner-server/pool/state/process"
+)
+
 // Configurer interface for reading plugin configuration
 type Configurer interface {
 	// UnmarshalKey reads configuration section into provided structure
@@ -8,5 +12,21 @@ type Configurer interface {
 	Has(name string) bool
 }
 
+// Informer is implemented by plugins exposing the state of their worker pool.
+// Only the http plugin is collected, other servers such as jobs or grpc have
+// pools of their own
+type Informer interface {
+	// Name returns the plugin name
+	Name() string
+	// Workers returns the current state of every worker
+	Workers() []*process.State
+}
+
 // configKey is the configuration section name for this plugin
 const configKey = "http_metrics"
+
+// httpConfigKey is the configuration section of the http plugin
+const httpConfigKey = "http"
+
+// httpPluginName is the name of the http plugin, the only informer collected
+const httpPluginName = "http"
@@ -8,12 +8,18 @@ import (
 type ErrorType string
 
 const (
-	ErrorTypeClientError ErrorType = "client_error" // 4xx errors
-	ErrorTypeServerError ErrorType = "server_error" // 5xx errors
-	ErrorTypeTimeout     ErrorType = "timeout"      // 408, 504
-	ErrorTypeNoWorkers   ErrorType = "no_workers"   // Worker pool exhausted
+	ErrorTypeClientError  ErrorType = "client_error"  // 4xx errors
+	ErrorTypeServerError  ErrorType = "server_error"  // 5xx errors
+	ErrorTypeTimeout      ErrorType = "timeout"       // 408, 504
+	ErrorTypeNoWorkers    ErrorType = "no_workers"    // Worker pool exhausted
+	ErrorTypeClientClosed ErrorType = "client_closed" // 499, client went away before the response
+	ErrorTypePanic        ErrorType = "panic"         // Handler panicked
 )
 
+// statusClientClosed is the nginx convention for requests the client
+// abandoned before a response was sent
+const statusClientClosed = 499
+
 // classifyError determines the error type based on status code and headers
 func classifyError(statusCode int, headers http.Header) ErrorType {
 	// Check for no workers condition first
@@ -23,6 +29,8 @@ func classifyError(statusCode int, headers http.Header) ErrorType {
 
 	// Classify by status code
 	switch {
+	case statusCode == statusClientClosed:
+		return ErrorTypeClientClosed
 	case statusCode == 408 || statusCode == 504:
 		return ErrorTypeTimeout
 	case statusCode >= 400 && statusCode < 500:
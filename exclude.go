@@ -0,0 +1,105 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// excludeFilter decides which requests are left out of metrics collection
+type excludeFilter struct {
+	paths      []*regexp.Regexp
+	endpoints  map[string]struct{}
+	methods    map[string]struct{}
+	userAgents []*regexp.Regexp
+	cidrs      []netip.Prefix
+}
+
+// newExcludeFilter builds the filter. Returns nil if nothing is excluded
+func newExcludeFilter(config ExcludeConfig) (*excludeFilter, error) {
+	if len(config.Paths) == 0 && len(config.Endpoints) == 0 && len(config.Methods) == 0 &&
+		len(config.UserAgents) == 0 && len(config.CIDRs) == 0 {
+		return nil, nil
+	}
+
+	paths, err := compilePatterns(config.Paths)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgents, err := compilePatterns(config.UserAgents)
+	if err != nil {
+		return nil, err
+	}
+
+	cidrs, err := parseCIDRs(config.CIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &excludeFilter{
+		paths:      paths,
+		endpoints:  make(map[string]struct{}, len(config.Endpoints)),
+		methods:    make(map[string]struct{}, len(config.Methods)),
+		userAgents: userAgents,
+		cidrs:      cidrs,
+	}
+	for _, endpoint := range config.Endpoints {
+		f.endpoints[endpoint] = struct{}{}
+	}
+	for _, method := range config.Methods {
+		f.methods[strings.ToUpper(method)] = struct{}{}
+	}
+
+	return f, nil
+}
+
+// request reports whether the request is excluded by method, path,
+// user agent or source address
+func (f *excludeFilter) request(r *http.Request) bool {
+	if _, ok := f.methods[r.Method]; ok {
+		return true
+	}
+
+	for _, regex := range f.paths {
+		if regex.MatchString(r.URL.Path) {
+			return true
+		}
+	}
+
+	if len(f.userAgents) > 0 {
+		ua := r.UserAgent()
+		for _, regex := range f.userAgents {
+			if regex.MatchString(ua) {
+				return true
+			}
+		}
+	}
+
+	if len(f.cidrs) > 0 {
+		if addr, ok := remoteAddr(r); ok && containsAddr(f.cidrs, addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// endpoint reports whether the endpoint label is excluded
+func (f *excludeFilter) endpoint(label string) bool {
+	_, ok := f.endpoints[label]
+	return ok
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, regex)
+	}
+	return compiled, nil
+}
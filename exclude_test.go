@@ -0,0 +1,170 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExcludeFilter(t *testing.T) {
+	f, err := newExcludeFilter(ExcludeConfig{
+		Paths:      []string{"^/health$"},
+		Methods:    []string{"options"},
+		UserAgents: []string{"^kube-probe/"},
+		CIDRs:      []string{"10.0.0.0/8", "192.0.2.7"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		ua       string
+		remote   string
+		excluded bool
+	}{
+		{"path", "GET", "/health", "", "198.51.100.1:1234", true},
+		{"path prefix only", "GET", "/health/deep", "", "198.51.100.1:1234", false},
+		{"method", "OPTIONS", "/users", "", "198.51.100.1:1234", true},
+		{"user agent", "GET", "/users", "kube-probe/1.29", "198.51.100.1:1234", true},
+		{"cidr", "GET", "/users", "", "10.1.2.3:1234", true},
+		{"single address", "GET", "/users", "", "192.0.2.7:1234", true},
+		{"regular request", "GET", "/users", "curl/8.0", "198.51.100.1:1234", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("User-Agent", tt.ua)
+			r.RemoteAddr = tt.remote
+			if got := f.request(r); got != tt.excluded {
+				t.Errorf("request = %v, want %v", got, tt.excluded)
+			}
+		})
+	}
+}
+
+func TestNewExcludeFilter(t *testing.T) {
+	if f, err := newExcludeFilter(ExcludeConfig{Count: true}); f != nil || err != nil {
+		t.Errorf("empty config = %v, %v; want no filter", f, err)
+	}
+	for _, config := range []ExcludeConfig{
+		{Paths: []string{"("}},
+		{UserAgents: []string{"["}},
+		{CIDRs: []string{"10.0.0.0/33"}},
+	} {
+		if _, err := newExcludeFilter(config); err == nil {
+			t.Errorf("config %+v accepted", config)
+		}
+	}
+}
+
+func TestMiddlewareExcluded(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.RouteHeader.Enabled = true
+		cfg.EndpointPatterns.Enabled = true
+		cfg.EndpointPatterns.Rules = []PatternRule{{Route: "/ready", Name: "ready"}}
+		cfg.Exclude = ExcludeConfig{Paths: []string{"^/health$"}, Endpoints: []string{"ready"}, Count: true}
+	})
+
+	handler := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Route", "health")
+		w.Header().Set("X-RR-Worker-Start", "1700000000")
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("excluded request lost http.Flusher")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}
+
+	for _, path := range []string{"/health", "/ready"} {
+		rec := serveRequest(p, handler, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Body.String() != "ok" {
+			t.Errorf("%s: body %q", path, rec.Body.String())
+		}
+		// internal headers never reach the client, recorded or not
+		for _, header := range []string{"X-RR-Route", "X-RR-Worker-Start"} {
+			if v := rec.Header().Get(header); v != "" {
+				t.Errorf("%s: %s leaked: %q", path, header, v)
+			}
+		}
+	}
+
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_excluded_requests_total", nil); v != 2 {
+		t.Errorf("excluded_requests_total = %v, want 2", v)
+	}
+	if v := metricValue(families, "rr_http_request_total", nil); v != -1 {
+		t.Errorf("request_total = %v, want no series", v)
+	}
+}
+
+func TestMiddlewareExcludedWithoutInternalHeaders(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectQueueTime = false
+		cfg.Exclude = ExcludeConfig{Methods: []string{"OPTIONS"}}
+	})
+
+	// nothing to strip, the handler gets the server's writer
+	rec := httptest.NewRecorder()
+	p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if w != http.ResponseWriter(rec) {
+			t.Errorf("writer = %T, want the server's writer", w)
+		}
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/users", nil))
+}
+
+func TestMiddlewareExcludedEndpointKeepsPatternSlots(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.RouteHeader.Enabled = true
+		cfg.EndpointPatterns.MaxPatterns = 1
+		cfg.EndpointPatterns.Rules = []PatternRule{
+			{Route: "/ready", Name: "ready"},
+			{Route: "/users/{id:int}", Name: "users"},
+		}
+		cfg.Exclude = ExcludeConfig{Endpoints: []string{"ready"}}
+	})
+
+	// the worker names the endpoint, the rule matched for the exclusion
+	// check must not take the only slot
+	serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Route", "user_show")
+	}, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	serveRequest(p, func(http.ResponseWriter, *http.Request) {}, httptest.NewRequest(http.MethodGet, "/users/2", nil))
+
+	families := gather(t, p)
+	for endpoint, want := range map[string]float64{"user_show": 1, "__overflow__": 1, "users": -1} {
+		if v := metricValue(families, "rr_http_requests_by_endpoint_total", map[string]string{"endpoint": endpoint}); v != want {
+			t.Errorf("requests_by_endpoint_total{endpoint=%s} = %v, want %v", endpoint, v, want)
+		}
+	}
+
+	// each request looked its endpoint up once
+	hits := metricValue(families, "rr_http_endpoint_cache_hits_total", nil)
+	misses := metricValue(families, "rr_http_endpoint_cache_misses_total", nil)
+	if hits+misses != 2 {
+		t.Errorf("endpoint cache lookups = %v, want 2", hits+misses)
+	}
+}
+
+func TestMiddlewareExcludedEndpointAfterReload(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.EndpointPatterns.Rules = []PatternRule{{Route: "/users/{id:int}", Name: "users"}}
+		cfg.Exclude = ExcludeConfig{Endpoints: []string{"ready"}}
+	})
+
+	// rules reloaded while the request is served are matched again
+	serveRequest(p, func(http.ResponseWriter, *http.Request) {
+		if err := p.endpointMatcher.Reload([]PatternRule{{Route: "/users/{id:int}", Name: "accounts"}}); err != nil {
+			t.Error(err)
+		}
+	}, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	if v := metricValue(gather(t, p), "rr_http_requests_by_endpoint_total", map[string]string{"endpoint": "accounts"}); v != 1 {
+		t.Errorf("requests_by_endpoint_total{endpoint=accounts} = %v, want 1", v)
+	}
+	if got := p.endpointMatcher.Match(RequestInfo{Method: http.MethodGet, Path: "/users/2"}); got != "accounts" {
+		t.Errorf("cached label = %q, want accounts", got)
+	}
+}
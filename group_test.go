@@ -0,0 +1,124 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestEndpointGroups(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.RouteHeader.Enabled = true
+		cfg.EndpointPatterns.Enabled = true
+		cfg.EndpointPatterns.DefaultGroup = "public"
+		cfg.EndpointPatterns.Rules = []PatternRule{
+			{Route: "/admin/users/{id:int}", Name: "admin_user", Group: "admin"},
+			{Route: "/users/{id:int}", Name: "user"},
+		}
+	})
+
+	tests := []struct {
+		path  string
+		route string
+		label string
+		group string
+	}{
+		{"/admin/users/1", "", "admin_user", "admin"},
+		{"/users/1", "", "user", "public"},
+		{"/unknown", "", "other", "public"},
+		// route names reported by the worker get the default group
+		{"/admin/users/1", "admin.users.show", "admin.users.show", "public"},
+	}
+	for _, tt := range tests {
+		res := p.endpoint(newRequestInfo(httptest.NewRequest(http.MethodGet, tt.path, nil)), tt.route, nil)
+		if res.label != tt.label || res.group != tt.group {
+			t.Errorf("%s (route %q) = %s/%s, want %s/%s", tt.path, tt.route, res.label, res.group, tt.label, tt.group)
+		}
+	}
+}
+
+func TestGroupLabel(t *testing.T) {
+	configure := func(groupLabel bool) func(*Config) {
+		return func(cfg *Config) {
+			cfg.GroupLabel = groupLabel
+			cfg.EndpointPatterns.Enabled = true
+			cfg.EndpointPatterns.Rules = []PatternRule{{Route: "/admin", Name: "admin", Group: "admin"}}
+		}
+	}
+
+	p := newTestPlugin(t, configure(true))
+	serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}, httptest.NewRequest(http.MethodGet, "/admin", nil))
+
+	families := gather(t, p)
+	for _, name := range []string{
+		"rr_http_requests_by_endpoint_total",
+		"rr_http_duration_by_endpoint_seconds",
+		"rr_http_errors_total",
+	} {
+		if v := metricValue(families, name, map[string]string{"endpoint": "admin", "group": "admin"}); v != 1 {
+			t.Errorf("%s{group=admin} = %v, want 1", name, v)
+		}
+	}
+
+	// off by default, enabling it changes the label set of existing series
+	if DefaultConfig().GroupLabel {
+		t.Error("group_label enabled by default")
+	}
+	p = newTestPlugin(t, configure(false))
+	serveRequest(p, func(http.ResponseWriter, *http.Request) {}, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	m := findMetric(gather(t, p), "rr_http_requests_by_endpoint_total", map[string]string{"endpoint": "admin"})
+	if m == nil {
+		t.Fatal("no requests_by_endpoint series")
+	}
+	for _, lp := range m.GetLabel() {
+		if lp.GetName() == "group" {
+			t.Error("group label present with group_label disabled")
+		}
+	}
+}
+
+func TestGroupBuckets(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.DurationBuckets = []float64{0.1, 1}
+		cfg.BucketProfiles = map[string][]float64{"slow": {1, 10, 60}, "batch": {60, 600}}
+		cfg.GroupBuckets = map[string]string{"reports": "slow"}
+		cfg.EndpointPatterns.Enabled = true
+		cfg.EndpointPatterns.Rules = []PatternRule{
+			{Route: "/reports/{id:int}", Name: "report", Group: "reports"},
+			// the buckets of a rule take precedence over the ones of its group
+			{Route: "/reports/export", Name: "export", Group: "reports", Buckets: "batch"},
+		}
+	})
+
+	ok := func(http.ResponseWriter, *http.Request) {}
+	for _, path := range []string{"/reports/1", "/reports/export", "/other"} {
+		serveRequest(p, ok, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	families := gather(t, p)
+	for endpoint, want := range map[string][]float64{
+		"report": {1, 10, 60},
+		"export": {60, 600},
+		"other":  {0.1, 1},
+	} {
+		m := findMetric(families, "rr_http_duration_by_endpoint_seconds", map[string]string{"endpoint": endpoint})
+		if m == nil {
+			t.Errorf("no duration_by_endpoint series for %s", endpoint)
+			continue
+		}
+		if got := upperBounds(m.GetHistogram()); !slices.Equal(got, want) {
+			t.Errorf("%s buckets = %v, want %v", endpoint, got, want)
+		}
+	}
+}
+
+func TestGroupBucketsUnknownProfile(t *testing.T) {
+	p := &Plugin{config: DefaultConfig()}
+	p.config.GroupBuckets = map[string]string{"reports": "missing"}
+	if err := p.init(); err == nil {
+		t.Error("unknown group bucket profile accepted")
+	}
+}
@@ -0,0 +1,146 @@
+package prometheus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// profiledHistogram is a histogram vector whose buckets depend on the
+// endpoint. It keeps one vector per bucket profile under the same metric
+// name, so the exposed metric stays stable while endpoints get their own
+// bucket layouts.
+//
+// A series may only live in one of the vectors, so every endpoint is pinned
+// to the profile it was first observed with. Conflicting profiles (e.g. two
+// rules with the same name) keep the pinned one until the rules are reloaded.
+// The first observation after a reload pins the endpoint again, if its profile
+// changed the old series are dropped
+type profiledHistogram struct {
+	vecs map[string]*prometheus.HistogramVec
+
+	mu         sync.RWMutex
+	pinned     map[string]profilePin
+	generation uint64
+}
+
+type profilePin struct {
+	profile    string
+	generation uint64
+}
+
+// newProfiledHistogram creates the vectors. opts.Buckets are the default
+// buckets used for the empty profile and unknown profiles
+func newProfiledHistogram(opts prometheus.HistogramOpts, labelNames []string, profiles map[string][]float64) *profiledHistogram {
+	h := &profiledHistogram{
+		vecs:   make(map[string]*prometheus.HistogramVec, len(profiles)+1),
+		pinned: make(map[string]profilePin),
+	}
+
+	h.vecs[""] = prometheus.NewHistogramVec(opts, labelNames)
+	for name, buckets := range profiles {
+		profileOpts := opts
+		profileOpts.Buckets = buckets
+		h.vecs[name] = prometheus.NewHistogramVec(profileOpts, labelNames)
+	}
+
+	return h
+}
+
+// Describe implements prometheus.Collector. All vectors share one descriptor
+func (h *profiledHistogram) Describe(ch chan<- *prometheus.Desc) {
+	h.vecs[""].Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (h *profiledHistogram) Collect(ch chan<- prometheus.Metric) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, vec := range h.vecs {
+		vec.Collect(ch)
+	}
+}
+
+// observe records the value for the endpoint using its bucket profile
+func (h *profiledHistogram) observe(profile, endpoint string, labels prometheus.Labels, value float64) {
+	if _, ok := h.vecs[profile]; !ok {
+		profile = ""
+	}
+
+	if len(h.vecs) == 1 {
+		h.vecs[""].With(labels).Observe(value)
+		return
+	}
+
+	// observations happen under the lock so that a concurrent repin cannot
+	// leave a series behind in the old vector
+	h.mu.RLock()
+	pin, ok := h.pinned[endpoint]
+	if ok && pin.generation == h.generation {
+		h.vecs[pin.profile].With(labels).Observe(value)
+		h.mu.RUnlock()
+		return
+	}
+	h.mu.RUnlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// the first observation after a reload pins the endpoint again
+	pin, ok = h.pinned[endpoint]
+	switch {
+	case ok && pin.generation == h.generation:
+		profile = pin.profile
+	case ok && pin.profile != profile:
+		h.vecs[pin.profile].DeletePartialMatch(prometheus.Labels{"endpoint": endpoint})
+		fallthrough
+	default:
+		h.pinned[endpoint] = profilePin{profile: profile, generation: h.generation}
+	}
+
+	h.vecs[profile].With(labels).Observe(value)
+}
+
+// repin lets endpoints move to a different profile, called after the rules are reloaded
+func (h *profiledHistogram) repin() {
+	h.mu.Lock()
+	h.generation++
+	h.mu.Unlock()
+}
+
+// orDefault returns buckets, or the defaults when empty
+func orDefault(buckets, defaults []float64) []float64 {
+	if len(buckets) == 0 {
+		return defaults
+	}
+	return buckets
+}
+
+// validateBuckets checks that buckets are strictly increasing
+func validateBuckets(name string, buckets []float64) error {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("%s: buckets must be in strictly increasing order, got %v", name, buckets)
+		}
+	}
+	return nil
+}
+
+// checkBucketProfiles verifies that the rules only refer to defined bucket profiles
+func checkBucketProfiles(rules []PatternRule, profiles map[string][]float64) error {
+	for _, rule := range rules {
+		if rule.Buckets == "" {
+			continue
+		}
+		if _, ok := profiles[rule.Buckets]; !ok {
+			id := rule.Name
+			if id == "" {
+				id = rule.Route + rule.Pattern
+			}
+			return fmt.Errorf("rule %q: unknown bucket profile %q", id, rule.Buckets)
+		}
+	}
+	return nil
+}
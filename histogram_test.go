@@ -0,0 +1,199 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newTestHistogram() *profiledHistogram {
+	return newProfiledHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "test_seconds",
+		Help:      "Test histogram.",
+		Buckets:   []float64{0.1, 1},
+	}, []string{"endpoint"}, map[string][]float64{
+		"slow": {1, 10, 60},
+	})
+}
+
+// histogramBuckets returns the bucket upper bounds and sample count of every
+// series of the collector by endpoint
+func histogramBuckets(t *testing.T, c prometheus.Collector) map[string][]float64 {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := make(map[string][]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			series[endpointLabel(m)] = upperBounds(m.GetHistogram())
+		}
+	}
+	return series
+}
+
+func endpointLabel(m *dto.Metric) string {
+	for _, lp := range m.GetLabel() {
+		if lp.GetName() == "endpoint" {
+			return lp.GetValue()
+		}
+	}
+	return ""
+}
+
+func upperBounds(h *dto.Histogram) []float64 {
+	bounds := make([]float64, 0, len(h.GetBucket()))
+	for _, b := range h.GetBucket() {
+		bounds = append(bounds, b.GetUpperBound())
+	}
+	return bounds
+}
+
+func TestProfiledHistogram(t *testing.T) {
+	h := newTestHistogram()
+
+	h.observe("", "fast", prometheus.Labels{"endpoint": "fast"}, 0.05)
+	h.observe("slow", "report", prometheus.Labels{"endpoint": "report"}, 30)
+	h.observe("missing", "unknown", prometheus.Labels{"endpoint": "unknown"}, 0.5)
+
+	series := histogramBuckets(t, h)
+	want := map[string][]float64{
+		"fast":    {0.1, 1},
+		"report":  {1, 10, 60},
+		"unknown": {0.1, 1},
+	}
+	for endpoint, buckets := range want {
+		if !slices.Equal(series[endpoint], buckets) {
+			t.Errorf("%s buckets = %v, want %v", endpoint, series[endpoint], buckets)
+		}
+	}
+}
+
+func TestProfiledHistogramPinning(t *testing.T) {
+	h := newTestHistogram()
+	labels := prometheus.Labels{"endpoint": "report"}
+
+	h.observe("", "report", labels, 1)
+	// a conflicting profile keeps the pinned one, the series stays in one vector
+	h.observe("slow", "report", labels, 1)
+	if got := histogramBuckets(t, h)["report"]; !slices.Equal(got, []float64{0.1, 1}) {
+		t.Errorf("buckets = %v, want the pinned default buckets", got)
+	}
+
+	// after a reload the endpoint moves to its new profile and the old series is dropped
+	h.repin()
+	h.observe("slow", "report", labels, 1)
+	if got := histogramBuckets(t, h)["report"]; !slices.Equal(got, []float64{1, 10, 60}) {
+		t.Errorf("buckets after repin = %v, want the slow profile", got)
+	}
+
+	// the first observation after a reload decides, the conflicting one follows it
+	h.repin()
+	h.observe("slow", "report", labels, 1)
+	h.observe("", "report", labels, 1)
+	series := histogramBuckets(t, h)
+	if got := series["report"]; !slices.Equal(got, []float64{1, 10, 60}) {
+		t.Errorf("buckets = %v, want the slow profile", got)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(h)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(families[0].GetMetric()); n != 1 {
+		t.Errorf("series = %d, want 1", n)
+	}
+	if count := families[0].GetMetric()[0].GetHistogram().GetSampleCount(); count != 3 {
+		t.Errorf("sample count = %d, want 3 since the last move", count)
+	}
+}
+
+func TestValidateBuckets(t *testing.T) {
+	for buckets, valid := range map[*[]float64]bool{
+		{}:            true,
+		{1}:           true,
+		{0.1, 1, 10}:  true,
+		{1, 1}:        false,
+		{1, 10, 5}:    false,
+		{-1, 0, 0.5}:  true,
+		{10, 1, 0.1}:  false,
+		{0.1, 0.2, 0}: false,
+	} {
+		if err := validateBuckets("test", *buckets); (err == nil) != valid {
+			t.Errorf("validateBuckets(%v) = %v, want valid %v", *buckets, err, valid)
+		}
+	}
+}
+
+func TestCheckBucketProfiles(t *testing.T) {
+	profiles := map[string][]float64{"slow": {1, 10}}
+
+	if err := checkBucketProfiles([]PatternRule{{Route: "/a", Buckets: "slow"}, {Route: "/b"}}, profiles); err != nil {
+		t.Error(err)
+	}
+	if err := checkBucketProfiles([]PatternRule{{Route: "/a", Buckets: "fast"}}, profiles); err == nil {
+		t.Error("unknown profile accepted")
+	}
+}
+
+func TestMiddlewareBucketProfiles(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.DurationBuckets = []float64{0.1, 1}
+		cfg.BucketProfiles = map[string][]float64{"slow": {1, 10, 60}}
+		cfg.EndpointPatterns.Enabled = true
+		cfg.EndpointPatterns.Rules = []PatternRule{
+			{Route: "/reports/{id:int}", Name: "report", Buckets: "slow"},
+			{Route: "/users/{id:int}", Name: "user"},
+		}
+	})
+
+	ok := func(w http.ResponseWriter, _ *http.Request) {}
+	serveRequest(p, ok, httptest.NewRequest(http.MethodGet, "/reports/1", nil))
+	serveRequest(p, ok, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	families := gather(t, p)
+	for endpoint, want := range map[string][]float64{
+		"report": {1, 10, 60},
+		"user":   {0.1, 1},
+	} {
+		m := findMetric(families, "rr_http_duration_by_endpoint_seconds", map[string]string{"endpoint": endpoint})
+		if m == nil {
+			t.Errorf("no duration_by_endpoint series for %s", endpoint)
+			continue
+		}
+		if got := upperBounds(m.GetHistogram()); !slices.Equal(got, want) {
+			t.Errorf("%s buckets = %v, want %v", endpoint, got, want)
+		}
+	}
+}
+
+func TestInitBucketProfileErrors(t *testing.T) {
+	tests := map[string]func(*Config){
+		"unknown profile": func(cfg *Config) {
+			cfg.EndpointPatterns.Enabled = true
+			cfg.EndpointPatterns.Rules = []PatternRule{{Route: "/a", Buckets: "missing"}}
+		},
+		"unsorted profile": func(cfg *Config) {
+			cfg.BucketProfiles = map[string][]float64{"slow": {10, 1}}
+		},
+	}
+	for name, configure := range tests {
+		p := &Plugin{config: DefaultConfig()}
+		configure(p.config)
+		if err := p.init(); err == nil {
+			t.Errorf("%s: init succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package prometheus
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// httpPoolConfig is the part of the http plugin configuration holding the pool size
+type httpPoolConfig struct {
+	Pool struct {
+		NumWorkers uint64 `mapstructure:"num_workers"`
+	} `mapstructure:"pool"`
+}
+
+// inflightTracker counts requests being served and integrates the count over
+// time, so that average and peak utilization can be reported per scrape
+// interval. It is the worker utilization source when no informer is available.
+//
+// The request path only touches atomics: every change claims the time since
+// the previous change and adds it, weighted with the busy workers, to the
+// area. The claimed intervals tile the timeline, so the area stays exact up
+// to concurrent changes being attributed to a neighbouring interval
+type inflightTracker struct {
+	workers float64
+	epoch   time.Time // monotonic origin of the nanosecond timestamps
+
+	current atomic.Int64
+	peak    atomic.Int64
+	area    atomic.Int64 // busy workers × nanoseconds since window
+	changed atomic.Int64 // nanoseconds since epoch of the last change
+	window  atomic.Int64 // nanoseconds since epoch of the window start
+
+	avgDesc  *prometheus.Desc
+	peakDesc *prometheus.Desc
+}
+
+func newInflightTracker(workers int) *inflightTracker {
+	return &inflightTracker{
+		workers: float64(workers),
+		epoch:   time.Now(),
+		avgDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "worker_utilization_avg_percent"),
+			"Time-weighted average of busy workers estimated from in-flight requests, relative to the configured workers (0-100), since the previous scrape.",
+			nil, nil,
+		),
+		peakDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "worker_utilization_peak_percent"),
+			"Peak of busy workers estimated from in-flight requests, relative to the configured workers (0-100), since the previous scrape.",
+			nil, nil,
+		),
+	}
+}
+
+// add changes the in-flight count by delta (+1 on start, -1 on finish)
+func (t *inflightTracker) add(delta int64) {
+	t.advance(t.now())
+
+	busy := t.busy(t.current.Add(delta))
+	for {
+		peak := t.peak.Load()
+		if busy <= peak || t.peak.CompareAndSwap(peak, busy) {
+			break
+		}
+	}
+}
+
+func (t *inflightTracker) now() int64 {
+	return int64(time.Since(t.epoch))
+}
+
+// advance accumulates the busy workers from the previous change up to now
+func (t *inflightTracker) advance(now int64) {
+	since := t.changed.Swap(now)
+	t.area.Add(t.busy(t.current.Load()) * (now - since))
+}
+
+// busy is the number of workers serving the current requests: requests
+// beyond the pool size wait in the queue
+func (t *inflightTracker) busy(current int64) int64 {
+	if t.workers > 0 && float64(current) > t.workers {
+		return int64(t.workers)
+	}
+	return current
+}
+
+// busyNow is the number of workers serving requests right now
+func (t *inflightTracker) busyNow() int64 {
+	return t.busy(t.current.Load())
+}
+
+// utilization converts a number of busy workers into a percentage of the pool
+func (t *inflightTracker) utilization(busy float64) float64 {
+	if t.workers <= 0 {
+		return 0
+	}
+	return busy / t.workers * 100
+}
+
+// flush returns the average and peak number of busy workers since the
+// previous call and starts a new window
+func (t *inflightTracker) flush() (float64, float64) {
+	now := t.now()
+	t.advance(now)
+
+	busy := t.busyNow()
+	area := t.area.Swap(0)
+	peak := t.peak.Swap(busy)
+	window := t.window.Swap(now)
+
+	avg := float64(busy)
+	if elapsed := now - window; elapsed > 0 {
+		avg = float64(area) / float64(elapsed)
+	}
+
+	return avg, float64(max(peak, busy))
+}
+
+// Describe implements prometheus.Collector
+func (t *inflightTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.avgDesc
+	ch <- t.peakDesc
+}
+
+// Collect implements prometheus.Collector. Every scrape starts a new window,
+// so with several scrapers each one sees the time since any previous scrape
+func (t *inflightTracker) Collect(ch chan<- prometheus.Metric) {
+	avg, peak := t.flush()
+	ch <- prometheus.MustNewConstMetric(t.avgDesc, prometheus.GaugeValue, t.utilization(avg))
+	ch <- prometheus.MustNewConstMetric(t.peakDesc, prometheus.GaugeValue, t.utilization(peak))
+}
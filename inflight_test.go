@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInflightTrackerWindow(t *testing.T) {
+	tr := newInflightTracker(2)
+	tr.flush()
+
+	// three requests on two workers, one of them queued
+	for i := 0; i < 3; i++ {
+		tr.add(1)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	avg, peak := tr.flush()
+	if peak != 2 {
+		t.Errorf("peak = %v, want 2 busy workers", peak)
+	}
+	if avg < 1.5 || avg > 2 {
+		t.Errorf("avg = %v, want about 2", avg)
+	}
+
+	// the next window starts with the requests still in flight
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		tr.add(-1)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	avg, peak = tr.flush()
+	if peak != 2 {
+		t.Errorf("peak = %v, want 2 carried into the window", peak)
+	}
+	if avg <= 0.2 || avg >= 1.8 {
+		t.Errorf("avg = %v, want about 1", avg)
+	}
+
+	if avg, peak = tr.flush(); avg != 0 || peak != 0 {
+		t.Errorf("idle window = %v, %v; want 0, 0", avg, peak)
+	}
+}
+
+func TestInflightTrackerConcurrent(t *testing.T) {
+	tr := newInflightTracker(8)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				tr.add(1)
+				tr.add(-1)
+			}
+		}()
+	}
+
+	// scrapes run while requests come and go
+	for i := 0; i < 10; i++ {
+		avg, peak := tr.flush()
+		if avg < 0 || avg > 8 || peak < 0 || peak > 8 {
+			t.Errorf("flush = %v, %v; want values within the pool size", avg, peak)
+		}
+	}
+	wg.Wait()
+
+	if busy := tr.busyNow(); busy != 0 {
+		t.Errorf("busy = %d after all requests finished", busy)
+	}
+	tr.flush()
+	if avg, peak := tr.flush(); avg != 0 || peak != 0 {
+		t.Errorf("flush = %v, %v; want 0, 0 when idle", avg, peak)
+	}
+}
+
+func TestInflightTrackerUtilization(t *testing.T) {
+	if u := newInflightTracker(4).utilization(1); u != 25 {
+		t.Errorf("utilization = %v, want 25", u)
+	}
+	if u := newInflightTracker(0).utilization(1); u != 0 {
+		t.Errorf("utilization without workers = %v, want 0", u)
+	}
+
+	// without a pool size nothing is capped
+	tr := newInflightTracker(0)
+	tr.add(3)
+	if busy := tr.busyNow(); busy != 3 {
+		t.Errorf("busy = %d, want 3", busy)
+	}
+}
+
+func TestMiddlewareInflight(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectWorkerInfo = true
+	})
+	p.inflight = newInflightTracker(4)
+
+	serveRequest(p, func(http.ResponseWriter, *http.Request) {
+		// the gauges are read at scrape time
+		families := gather(t, p)
+		if v := metricValue(families, "rr_http_active_workers", nil); v != 1 {
+			t.Errorf("active_workers during the request = %v, want 1", v)
+		}
+		if v := metricValue(families, "rr_http_worker_utilization_peak_percent", nil); v != 25 {
+			t.Errorf("worker_utilization_peak_percent = %v, want 25", v)
+		}
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_active_workers", nil); v != 0 {
+		t.Errorf("active_workers after the request = %v, want 0", v)
+	}
+	if v := metricValue(families, "rr_http_worker_utilization_avg_percent", nil); math.IsNaN(v) || v < 0 || v > 25 {
+		t.Errorf("worker_utilization_avg_percent = %v, want between 0 and 25", v)
+	}
+}
+
+func BenchmarkInflightAdd(b *testing.B) {
+	tr := newInflightTracker(64)
+
+	b.ReportAllocs()
+	b.SetParallelism(64)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tr.add(1)
+			tr.add(-1)
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package prometheus
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LintSeverity tells whether a lint issue breaks the configuration
+type LintSeverity string
+
+const (
+	LintError   LintSeverity = "error"   // the plugin refuses to start with this rule
+	LintWarning LintSeverity = "warning" // the rule works but likely not as intended
+)
+
+// LintKind classifies issues found in endpoint rules
+type LintKind string
+
+const (
+	LintInvalidRule   LintKind = "invalid_rule"   // pattern or route does not compile
+	LintInvalidName   LintKind = "invalid_name"   // name is unusable as a label value
+	LintDuplicateName LintKind = "duplicate_name" // several rules produce the same label
+	LintShadowed      LintKind = "shadowed"       // another rule always wins over this one
+)
+
+// maxEndpointNameLength is the longest rule name accepted by the linter
+const maxEndpointNameLength = 256
+
+// LintIssue describes a problem found in the endpoint rules
+type LintIssue struct {
+	Severity LintSeverity
+	Kind     LintKind
+
+	// Rule is the index of the offending rule
+	Rule int
+
+	// Other is the index of the related rule (shadowing or duplicate), -1 if none
+	Other int
+
+	Message string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: rule #%d: %s", i.Severity, i.Rule, i.Message)
+}
+
+// LintRules checks endpoint rules for invalid patterns and names, duplicate
+// names and rules that can never match because another rule takes precedence.
+//
+// Shadowing is detected by generating sample paths for every rule and
+// resolving them with the same precedence the matcher uses, so it is a
+// strong hint rather than a proof.
+func LintRules(rules []PatternRule) []LintIssue {
+	var issues []LintIssue
+
+	rs := newRuleSet(len(rules))
+	compiled := make(map[int]compiledRule, len(rules))
+	names := make(map[string]int, len(rules))
+
+	for i, rule := range rules {
+		c, err := compileRule(rule)
+		if err != nil {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Kind:     LintInvalidRule,
+				Rule:     i,
+				Other:    -1,
+				Message:  err.Error(),
+			})
+			continue
+		}
+		c.index = i
+		rs.add(c)
+		compiled[i] = c
+
+		if msg := validateEndpointName(c.name); msg != "" {
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Kind:     LintInvalidName,
+				Rule:     i,
+				Other:    -1,
+				Message:  msg,
+			})
+		}
+
+		if first, ok := names[c.name]; ok {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Kind:     LintDuplicateName,
+				Rule:     i,
+				Other:    first,
+				Message:  fmt.Sprintf("name %q is already used by rule #%d", c.name, first),
+			})
+		} else {
+			names[c.name] = i
+		}
+	}
+
+	for i := range rules {
+		c, ok := compiled[i]
+		if !ok {
+			continue
+		}
+		if winner, ok := shadowedBy(rs, &c); ok {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Kind:     LintShadowed,
+				Rule:     i,
+				Other:    winner,
+				Message:  fmt.Sprintf("never matches, rule #%d takes precedence", winner),
+			})
+		}
+	}
+
+	sort.SliceStable(issues, func(a, b int) bool {
+		return issues[a].Rule < issues[b].Rule
+	})
+
+	return issues
+}
+
+// validateEndpointName returns a description of why the name is not a usable label, or ""
+func validateEndpointName(name string) string {
+	switch {
+	case name == "":
+		return "name is empty"
+	case !utf8.ValidString(name):
+		return "name is not valid UTF-8"
+	case len(name) > maxEndpointNameLength:
+		return fmt.Sprintf("name is longer than %d bytes", maxEndpointNameLength)
+	case strings.TrimSpace(name) != name:
+		return "name has leading or trailing whitespace"
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "name contains control characters"
+	}
+	return ""
+}
+
+// shadowedBy reports whether every sample request of the rule resolves to
+// another rule, and returns the first such rule
+func shadowedBy(rs *ruleSet, rule *compiledRule) (int, bool) {
+	path, ok := samplePath(rule)
+	if !ok {
+		return 0, false
+	}
+
+	// unscoped rules also serve methods no rule is scoped to
+	methods := []string{"GET", lintMethod}
+	if rule.methods != nil {
+		methods = methods[:0]
+		for method := range rule.methods {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+	}
+
+	// a reserved name no rule is expected to be scoped to
+	host := "lint.invalid"
+	if rule.host != "" {
+		host = strings.Replace(rule.host, "*", "sub", 1)
+	}
+
+	winner := -1
+	for _, method := range methods {
+		match := rs.lookup(RequestInfo{Method: method, Host: host, Path: path})
+		if match == nil || match.index == rule.index {
+			return 0, false
+		}
+		if winner == -1 {
+			winner = match.index
+		}
+	}
+
+	return winner, true
+}
+
+// lintMethod is a request method no rule is expected to be scoped to
+const lintMethod = "LINT"
+
+// routeSamples are placeholder values used to build sample paths
+var routeSamples = map[string]string{
+	"":      "x",
+	"int":   "1",
+	"uuid":  "123e4567-e89b-12d3-a456-426614174000",
+	"hex":   "a1",
+	"alpha": "a",
+	"alnum": "a1",
+	"slug":  "a-1",
+}
+
+// samplePath builds a path the rule matches
+func samplePath(rule *compiledRule) (string, bool) {
+	if rule.route != nil {
+		var sb strings.Builder
+		for _, seg := range rule.route.segments {
+			sb.WriteByte('/')
+			switch seg.kind {
+			case segmentLiteral:
+				sb.WriteString(seg.literal)
+			case segmentParam:
+				sb.WriteString(routeSamples[seg.typ])
+			case segmentCatchAll:
+				sb.WriteString("x")
+			}
+		}
+		return sb.String(), true
+	}
+
+	re, err := syntax.Parse(rule.regex.String(), syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+
+	var sb strings.Builder
+	writeRegexSample(&sb, re.Simplify())
+	path := sb.String()
+
+	return path, rule.regex.MatchString(path)
+}
+
+// writeRegexSample writes a short string matched by the regex
+func writeRegexSample(sb *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		sb.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		sb.WriteRune(sampleRune(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		sb.WriteByte('x')
+	case syntax.OpCapture, syntax.OpPlus:
+		writeRegexSample(sb, re.Sub[0])
+	case syntax.OpRepeat:
+		for i := 0; i < re.Min; i++ {
+			writeRegexSample(sb, re.Sub[0])
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			writeRegexSample(sb, sub)
+		}
+	case syntax.OpAlternate:
+		writeRegexSample(sb, re.Sub[0])
+	default:
+		// empty-width assertions, star and quest produce nothing
+	}
+}
+
+// sampleRune picks a readable rune from character class ranges
+func sampleRune(ranges []rune) rune {
+	for _, preferred := range [][2]rune{{'a', 'z'}, {'0', '9'}, {'A', 'Z'}, {'!', '~'}} {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			lo, hi := max(ranges[i], preferred[0]), min(ranges[i+1], preferred[1])
+			if lo <= hi {
+				return lo
+			}
+		}
+	}
+	if len(ranges) > 0 {
+		return ranges[0]
+	}
+	return 'x'
+}
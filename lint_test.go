@@ -0,0 +1,170 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintRules(t *testing.T) {
+	rules := []PatternRule{
+		{Route: "/users/{id}", Name: "user"},                               // 0
+		{Route: "/users/{id:int}", Name: "user"},                           // 1 duplicate name
+		{Route: "/orders/{id:float}"},                                      // 2 invalid route
+		{Pattern: "^/admin/.*", Name: " admin"},                            // 3 invalid name
+		{Pattern: "^/admin/users$", Name: "admin_users"},                   // 4 shadowed by 3
+		{Route: "/items/{id:int}", Name: "item", Methods: []string{"GET"}}, // 5
+		{Route: "/items/{id:int}", Name: "item_write", Methods: []string{"POST"}},
+		{Pattern: "^/health$", Name: "health"},
+	}
+
+	type key struct {
+		kind  LintKind
+		rule  int
+		other int
+	}
+	want := map[key]LintSeverity{
+		{LintDuplicateName, 1, 0}: LintWarning,
+		{LintInvalidRule, 2, -1}:  LintError,
+		{LintInvalidName, 3, -1}:  LintError,
+		{LintShadowed, 4, 3}:      LintWarning,
+	}
+
+	issues := LintRules(rules)
+	for i, issue := range issues {
+		k := key{issue.Kind, issue.Rule, issue.Other}
+		severity, ok := want[k]
+		if !ok {
+			t.Errorf("unexpected issue %s", issue)
+			continue
+		}
+		if issue.Severity != severity {
+			t.Errorf("%s: severity %s, want %s", issue, issue.Severity, severity)
+		}
+		if i > 0 && issues[i-1].Rule > issue.Rule {
+			t.Errorf("issues are not sorted by rule: %v", issues)
+		}
+		delete(want, k)
+	}
+	for k := range want {
+		t.Errorf("missing %s issue for rule #%d", k.kind, k.rule)
+	}
+}
+
+func TestLintRulesClean(t *testing.T) {
+	rules := []PatternRule{
+		// the typed route wins only for numeric ids, the untyped one still matches others
+		{Route: "/users/{id:int}", Name: "user"},
+		{Route: "/users/{name}", Name: "user_by_name"},
+		{Route: "/users/me", Name: "me"},
+		{Route: "/files/*path", Name: "file"},
+		{Pattern: "^/api/v[0-9]+/status$", Name: "status"},
+		{Route: "/tenants/{id:uuid}", Name: "tenant_admin", Host: "admin.example.com"},
+		{Route: "/tenants/{id:uuid}", Name: "tenant", Host: "*.example.com"},
+	}
+
+	if issues := LintRules(rules); len(issues) != 0 {
+		t.Errorf("issues = %v, want none", issues)
+	}
+}
+
+func TestLintShadowedOnSharedLeaf(t *testing.T) {
+	// rules on the same route are tried in declaration order, scoped rules
+	// declared first still leave the other methods to the unscoped one
+	issues := LintRules([]PatternRule{
+		{Route: "/orders/{id:int}", Name: "order_read", Methods: []string{"GET", "HEAD"}},
+		{Route: "/orders/{id:int}", Name: "order_write", Methods: []string{"PUT"}},
+		{Route: "/orders/{id:int}", Name: "order_shop", Host: "example.com"},
+		{Route: "/orders/{id:int}", Name: "order"},
+	})
+	if len(issues) != 0 {
+		t.Errorf("issues = %v, want none", issues)
+	}
+
+	// declared after the unscoped rule they can never match
+	issues = LintRules([]PatternRule{
+		{Route: "/orders/{id:int}", Name: "order"},
+		{Route: "/orders/{id:int}", Name: "order_read", Methods: []string{"GET", "HEAD"}},
+		{Route: "/orders/{id:int}", Name: "order_admin", Host: "admin.example.com"},
+	})
+	if len(issues) != 2 {
+		t.Fatalf("issues = %v, want rules #1 and #2 shadowed", issues)
+	}
+	for i, issue := range issues {
+		if issue.Kind != LintShadowed || issue.Rule != i+1 || issue.Other != 0 {
+			t.Errorf("issue %s, want rule #%d shadowed by #0", issue, i+1)
+		}
+	}
+}
+
+func TestValidateEndpointName(t *testing.T) {
+	tests := map[string]bool{
+		"user":                   true,
+		"/api/users/:id":         true,
+		"":                       false,
+		" user":                  false,
+		"user\n":                 false,
+		"us\x00er":               false,
+		"\xff":                   false,
+		strings.Repeat("a", 256): true,
+		strings.Repeat("a", 257): false,
+	}
+	for name, valid := range tests {
+		if got := validateEndpointName(name) == ""; got != valid {
+			t.Errorf("validateEndpointName(%q) valid = %v, want %v", name, got, valid)
+		}
+	}
+}
+
+func TestSamplePath(t *testing.T) {
+	tests := []PatternRule{
+		{Route: "/users/{id:int}/posts/{slug:slug}"},
+		{Route: "/files/*path"},
+		{Pattern: "^/api/v[0-9]+/users/[a-f0-9]{8}$"},
+		{Pattern: "^/(reports|exports)/\\d+\\.csv$"},
+		{Pattern: "^/search/?$"},
+	}
+
+	for _, rule := range tests {
+		c, err := compileRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, ok := samplePath(&c)
+		if !ok {
+			t.Errorf("no sample path for %+v", rule)
+			continue
+		}
+		rs := newRuleSet(1)
+		rs.add(c)
+		if rs.lookup(RequestInfo{Method: "GET", Path: path}) == nil {
+			t.Errorf("sample %q does not match %+v", path, rule)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	em := newTestMatcher(t, EndpointPatternsConfig{
+		MaxPatterns: 1,
+		Rules: []PatternRule{
+			{Route: "/users/{id:int}", Name: "user", Group: "accounts"},
+			{Route: "/orders/{id:int}", Name: "order"},
+		},
+	})
+
+	match := em.Explain(RequestInfo{Method: "GET", Path: "/orders/1"})
+	if match.Rule != 1 || match.Label != "order" || match.Group != "other" {
+		t.Errorf("Explain(/orders/1) = %+v", match)
+	}
+	match = em.Explain(RequestInfo{Method: "GET", Path: "/users/1"})
+	if match.Rule != 0 || match.Label != "user" || match.Group != "accounts" {
+		t.Errorf("Explain(/users/1) = %+v", match)
+	}
+	if match = em.Explain(RequestInfo{Method: "GET", Path: "/missing"}); match.Rule != -1 || match.Label != "other" {
+		t.Errorf("Explain(/missing) = %+v", match)
+	}
+
+	// a dry run does not take max_patterns slots
+	if got := em.Match(RequestInfo{Method: "GET", Path: "/users/2"}); got != "user" {
+		t.Errorf("Match after Explain = %q, want user", got)
+	}
+}
@@ -1,43 +1,295 @@
 package prometheus
 
 import (
+	"fmt"
+	"net"
+	"net/http"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // EndpointMatcher matches request paths to endpoint patterns
-// Uses LRU cache to minimize regex matching overhead
+// Route template rules are resolved through a segment trie, regex rules
+// are only scanned (in order) when no route matches.
+// Uses a sharded LRU cache to minimize regex matching overhead
 type EndpointMatcher struct {
-	rules    []compiledRule
+	rules    *ruleSet
 	cache    *endpointCache
 	fallback string
 	mu       sync.RWMutex
+
+	// defaultGroup is the group of endpoints without one
+	defaultGroup string
+
+	// scoped mirrors rules.scoped for building cache keys without locking
+	scoped atomic.Bool
+
+	// normalizer rewrites unmatched paths, nil when auto-normalization is off
+	normalizer *pathNormalizer
+
+	// captureNormalizer serves ${ref:norm} references in rule names
+	captureNormalizer *pathNormalizer
+
+	// Cardinality protection: distinct labels handed out so far
+	maxPatterns   int
+	overflowLabel string
+	labels        map[string]struct{}
+	labelsMu      sync.RWMutex
+	overflowTotal prometheus.Counter
+
+	// exempt labels never count towards max_patterns, e.g. excluded endpoints
+	exempt map[string]struct{}
+
+	// cacheMetrics are the cache self-metrics
+	cacheMetrics []prometheus.Collector
+}
+
+// RequestInfo describes the parts of a request endpoint rules match on
+type RequestInfo struct {
+	Method string
+	Host   string
+	Path   string
+}
+
+// newRequestInfo extracts the matching descriptor from a request.
+// The host is lowercased and stripped of its port
+func newRequestInfo(r *http.Request) RequestInfo {
+	return RequestInfo{
+		Method: r.Method,
+		Host:   NormalizeHost(r.Host),
+		Path:   r.URL.Path,
+	}
+}
+
+// NormalizeHost lowercases a host and strips its port, the form hosts have
+// when matched against host-scoped rules and the host label allowlist
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
 }
 
 type compiledRule struct {
 	regex *regexp.Regexp
+	route *routeTemplate
 	name  string
+
+	// buckets is the bucket profile of the rule, empty for the default buckets
+	buckets string
+
+	// group is the endpoint group of the rule, empty for the default group
+	group string
+
+	// nameParts holds references to captures in the name, nil for static names
+	nameParts []namePart
+
+	// index is the position of the rule in the configuration
+	index int
+
+	// Optional request scoping
+	methods map[string]struct{}
+	host    string
+}
+
+// compileRule builds a matcher for either a regex or a route template rule
+func compileRule(rule PatternRule) (compiledRule, error) {
+	var compiled compiledRule
+	var err error
+
+	switch {
+	case rule.Pattern != "" && rule.Route != "":
+		return compiledRule{}, fmt.Errorf("rule %q: pattern and route are mutually exclusive", rule.Name)
+	case rule.Pattern == "" && rule.Route == "":
+		return compiledRule{}, fmt.Errorf("rule %q: either pattern or route is required", rule.Name)
+	case rule.Route != "":
+		compiled.route, err = parseRoute(rule.Route)
+		if err != nil {
+			return compiledRule{}, err
+		}
+		compiled.name = rule.Name
+		if compiled.name == "" {
+			compiled.name = rule.Route
+		}
+	default:
+		compiled.regex, err = regexp.Compile(rule.Pattern)
+		if err != nil {
+			return compiledRule{}, err
+		}
+		compiled.name = rule.Name
+	}
+
+	// route templates used as names contain {placeholders}, not references
+	if rule.Name != "" {
+		if compiled.nameParts, err = parseNameTemplate(rule.Name); err != nil {
+			return compiledRule{}, err
+		}
+		if compiled.route != nil {
+			err = bindNameTemplate(rule.Name, compiled.nameParts, compiled.route.params(), compiled.route.paramIndex)
+		} else {
+			err = bindNameTemplate(rule.Name, compiled.nameParts, compiled.regex.NumSubexp(), compiled.regex.SubexpIndex)
+		}
+		if err != nil {
+			return compiledRule{}, err
+		}
+		// a name whose dollar signs are all literal is static
+		if len(compiled.nameParts) == 1 && compiled.nameParts[0].ref == "" {
+			compiled.name, compiled.nameParts = compiled.nameParts[0].literal, nil
+		}
+	}
+
+	compiled.buckets = rule.Buckets
+	compiled.group = rule.Group
+
+	return scopeRule(compiled, rule), nil
+}
+
+// label returns the endpoint label for a path matched by the rule
+func (r *compiledRule) label(path string, normalizer *pathNormalizer) string {
+	if r.nameParts == nil {
+		return r.name
+	}
+
+	var captures []string
+	if r.route != nil {
+		captures = r.route.captures(path)
+	} else {
+		captures = r.regex.FindStringSubmatch(path)
+	}
+
+	return expandName(r.nameParts, captures, normalizer)
+}
+
+// scopeRule applies the optional methods and host restrictions of a rule
+func scopeRule(compiled compiledRule, rule PatternRule) compiledRule {
+	if len(rule.Methods) > 0 {
+		compiled.methods = make(map[string]struct{}, len(rule.Methods))
+		for _, method := range rule.Methods {
+			compiled.methods[strings.ToUpper(method)] = struct{}{}
+		}
+	}
+	compiled.host = strings.ToLower(rule.Host)
+	return compiled
+}
+
+func (r *compiledRule) scoped() bool {
+	return r.methods != nil || r.host != ""
+}
+
+// accepts checks the method and host restrictions of the rule
+func (r *compiledRule) accepts(req RequestInfo) bool {
+	if r.methods != nil {
+		if _, ok := r.methods[req.Method]; !ok {
+			return false
+		}
+	}
+
+	switch {
+	case r.host == "":
+		return true
+	case strings.HasPrefix(r.host, "*."):
+		// wildcard matches subdomains only: *.example.com matches api.example.com
+		return strings.HasSuffix(req.Host, r.host[1:])
+	default:
+		return req.Host == r.host
+	}
+}
+
+func (r *compiledRule) match(req RequestInfo) bool {
+	if !r.accepts(req) {
+		return false
+	}
+	if r.route != nil {
+		return r.route.match(req.Path)
+	}
+	return r.regex.MatchString(req.Path)
+}
+
+// ruleSet is an immutable set of compiled rules, swapped as a whole on reload
+type ruleSet struct {
+	routes *routeNode
+	rules  []compiledRule
+
+	// scoped is set when any rule is limited to methods or a host,
+	// cache keys then include both
+	scoped bool
+}
+
+// compileRuleSet compiles all rules, failing on the first invalid one
+func compileRuleSet(rules []PatternRule) (*ruleSet, error) {
+	rs := newRuleSet(len(rules))
+	for i, rule := range rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		compiled.index = i
+		rs.add(compiled)
+	}
+
+	return rs, nil
+}
+
+func newRuleSet(capacity int) *ruleSet {
+	return &ruleSet{
+		routes: newRouteNode(),
+		rules:  make([]compiledRule, 0, capacity),
+	}
+}
+
+// add registers a compiled rule. Must not be called once the set is in use
+func (rs *ruleSet) add(rule compiledRule) {
+	rs.scoped = rs.scoped || rule.scoped()
+	if rule.route != nil {
+		rs.routes.insert(&rule)
+		return
+	}
+	rs.rules = append(rs.rules, rule)
+}
+
+// lookup returns the first rule matching the request or nil.
+// Route templates are tried first, regex rules in order afterwards
+func (rs *ruleSet) lookup(req RequestInfo) *compiledRule {
+	if rs.routes != nil {
+		if rule := rs.routes.lookup(req); rule != nil {
+			return rule
+		}
+	}
+
+	for i := range rs.rules {
+		if rs.rules[i].match(req) {
+			return &rs.rules[i]
+		}
+	}
+
+	return nil
 }
 
 // NewEndpointMatcher creates a new endpoint matcher from configuration
 func NewEndpointMatcher(config EndpointPatternsConfig) (*EndpointMatcher, error) {
+	overflowTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "endpoint_overflow_total",
+		Help:      "Total number of requests whose endpoint label collapsed into the overflow label after max_patterns was reached.",
+	})
+
 	if !config.Enabled {
 		return &EndpointMatcher{
-			fallback: "other",
-			cache:    newEndpointCache(1),
+			rules:         &ruleSet{},
+			fallback:      "other",
+			defaultGroup:  defaultGroup(config),
+			cache:         newEndpointCache(1, 0),
+			overflowTotal: overflowTotal,
 		}, nil
 	}
 
-	rules := make([]compiledRule, 0, len(config.Rules))
-	for _, rule := range config.Rules {
-		regex, err := regexp.Compile(rule.Pattern)
-		if err != nil {
-			return nil, err
-		}
-		rules = append(rules, compiledRule{
-			regex: regex,
-			name:  rule.Name,
-		})
+	rules, err := compileRuleSet(config.Rules)
+	if err != nil {
+		return nil, err
 	}
 
 	cacheSize := config.CacheSize
@@ -45,140 +297,252 @@ func NewEndpointMatcher(config EndpointPatternsConfig) (*EndpointMatcher, error)
 		cacheSize = 10000
 	}
 
-	return &EndpointMatcher{
-		rules:    rules,
-		cache:    newEndpointCache(cacheSize),
-		fallback: "other",
-	}, nil
-}
+	normalizer, err := newPathNormalizer(config.AutoNormalize)
+	if err != nil {
+		return nil, err
+	}
 
-// Match returns the endpoint pattern for a given path
-// Uses cached results when available, falls back to regex matching
-func (em *EndpointMatcher) Match(path string) string {
-	// Check cache first (read lock)
-	if cached, ok := em.cache.Get(path); ok {
-		return cached
+	overflowLabel := config.OverflowLabel
+	if overflowLabel == "" {
+		overflowLabel = "__overflow__"
 	}
 
-	// Try rules
-	em.mu.RLock()
-	defer em.mu.RUnlock()
+	captureNormalizer := normalizer
+	if captureNormalizer == nil {
+		captureNormalizer = newDefaultNormalizer()
+	}
 
-	for _, rule := range em.rules {
-		if rule.regex.MatchString(path) {
-			em.cache.Set(path, rule.name)
-			return rule.name
-		}
+	cache := newEndpointCache(cacheSize, config.CacheMaxKeyLength)
+
+	em := &EndpointMatcher{
+		rules:             rules,
+		cache:             cache,
+		cacheMetrics:      cache.collectors(),
+		fallback:          "other",
+		defaultGroup:      defaultGroup(config),
+		normalizer:        normalizer,
+		captureNormalizer: captureNormalizer,
+		maxPatterns:       config.MaxPatterns,
+		overflowLabel:     overflowLabel,
+		labels:            make(map[string]struct{}),
+		overflowTotal:     overflowTotal,
 	}
+	em.scoped.Store(rules.scoped)
 
-	// No match - use fallback
-	em.cache.Set(path, em.fallback)
-	return em.fallback
+	return em, nil
 }
 
-// endpointCache is a simple LRU cache for endpoint matching results
-type endpointCache struct {
-	capacity int
-	items    map[string]*cacheNode
-	head     *cacheNode
-	tail     *cacheNode
-	mu       sync.RWMutex
+func defaultGroup(config EndpointPatternsConfig) string {
+	if config.DefaultGroup == "" {
+		return "other"
+	}
+	return config.DefaultGroup
 }
 
-type cacheNode struct {
-	key   string
-	value string
-	prev  *cacheNode
-	next  *cacheNode
+// Reload atomically replaces the rules and invalidates the cache.
+// Labels counted towards max_patterns keep their slots, their series are
+// still exported. On error the previous rules are kept
+func (em *EndpointMatcher) Reload(rules []PatternRule) error {
+	rs, err := compileRuleSet(rules)
+	if err != nil {
+		return err
+	}
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	em.rules = rs
+	em.scoped.Store(rs.scoped)
+	em.cache.Purge()
+
+	return nil
 }
 
-func newEndpointCache(capacity int) *endpointCache {
-	return &endpointCache{
-		capacity: capacity,
-		items:    make(map[string]*cacheNode, capacity),
-	}
+// Match returns the endpoint pattern for a given request
+// Uses cached results when available, falls back to rule matching
+func (em *EndpointMatcher) Match(req RequestInfo) string {
+	return em.matchResult(req).label
 }
 
-func (c *endpointCache) Get(key string) (string, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// endpointResult is the outcome of matching a request, cached per path
+type endpointResult struct {
+	label string
 
-	if node, ok := c.items[key]; ok {
-		return node.value, true
-	}
-	return "", false
+	// buckets is the bucket profile of the matching rule, empty for the default buckets
+	buckets string
+
+	group string
 }
 
-func (c *endpointCache) Set(key, value string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// matchResult is Match returning the whole result
+func (em *EndpointMatcher) matchResult(req RequestInfo) endpointResult {
+	res := em.lookup(req)
+	em.countOverflow(res.label)
+	return res
+}
 
-	// Check if key exists
-	if node, ok := c.items[key]; ok {
-		node.value = value
-		c.moveToFront(node)
-		return
+// lookup is matchResult without counting overflows
+func (em *EndpointMatcher) lookup(req RequestInfo) endpointResult {
+	// Check cache first, only the key's shard is locked
+	if res, ok := em.cache.Get(cacheKey(req, em.scoped.Load())); ok {
+		return res
 	}
+	return em.match(req)
+}
 
-	// Create new node
-	node := &cacheNode{
-		key:   key,
-		value: value,
+// match resolves and caches the result. The rules lock is held while
+// caching so that a concurrent Reload cannot leave stale entries behind
+func (em *EndpointMatcher) match(req RequestInfo) endpointResult {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	return em.store(req, em.resolve(req))
+}
+
+// endpointProbe is a match that did not take a max_patterns slot yet
+type endpointProbe struct {
+	result endpointResult
+
+	// rules the result was resolved with, nil if it came from the cache
+	rules *ruleSet
+}
+
+// peek resolves the request without admitting its label, for decisions
+// taken before the request is recorded. settle completes the match
+func (em *EndpointMatcher) peek(req RequestInfo) endpointProbe {
+	if res, ok := em.cache.Get(cacheKey(req, em.scoped.Load())); ok {
+		return endpointProbe{result: res}
 	}
 
-	// Add to cache
-	if c.head == nil {
-		c.head = node
-		c.tail = node
-	} else {
-		node.next = c.head
-		c.head.prev = node
-		c.head = node
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	return endpointProbe{result: em.resolve(req), rules: em.rules}
+}
+
+// settle is matchResult for a peeked request, the rules are only matched
+// again if they were reloaded in between
+func (em *EndpointMatcher) settle(req RequestInfo, probe endpointProbe) endpointResult {
+	res := probe.result
+	if probe.rules != nil {
+		em.mu.RLock()
+		if probe.rules != em.rules {
+			res = em.resolve(req)
+		}
+		res = em.store(req, res)
+		em.mu.RUnlock()
 	}
 
-	c.items[key] = node
+	em.countOverflow(res.label)
+	return res
+}
 
-	// Evict if over capacity
-	if len(c.items) > c.capacity {
-		c.removeTail()
+// store admits the label of a resolved result and caches it. Callers must
+// hold the rules lock
+func (em *EndpointMatcher) store(req RequestInfo, res endpointResult) endpointResult {
+	if label := em.admit(res.label); label != res.label {
+		// overflowed labels use the default buckets, the group is bounded by the config and kept
+		res = endpointResult{label: label, group: res.group}
 	}
+	em.cache.Set(cacheKey(req, em.rules.scoped), res)
+	return res
 }
 
-func (c *endpointCache) moveToFront(node *cacheNode) {
-	if node == c.head {
-		return
+func cacheKey(req RequestInfo, scoped bool) string {
+	if scoped {
+		return req.Method + " " + req.Host + req.Path
 	}
+	return req.Path
+}
+
+// RuleMatch is the dry-run outcome of matching a request
+type RuleMatch struct {
+	// Rule is the index of the matching rule in the configuration, -1 if none matched
+	Rule int
 
-	// Remove from current position
-	if node.prev != nil {
-		node.prev.next = node.next
+	// Label is the endpoint label the request gets, before max_patterns is applied
+	Label string
+
+	// Group is the endpoint group of the request
+	Group string
+}
+
+// Explain reports which rule matches the request and the label it produces.
+// Bypasses the cache and does not count towards max_patterns
+func (em *EndpointMatcher) Explain(req RequestInfo) RuleMatch {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+
+	match := RuleMatch{Rule: -1}
+	if rule := em.rules.lookup(req); rule != nil {
+		match.Rule = rule.index
 	}
-	if node.next != nil {
-		node.next.prev = node.prev
+
+	res := em.resolve(req)
+	match.Label, match.Group = res.label, res.group
+	return match
+}
+
+// Label applies max_patterns protection to an endpoint name that was not
+// produced by the rules, e.g. a route name reported by the worker
+func (em *EndpointMatcher) Label(name string) string {
+	label := em.admit(name)
+	em.countOverflow(label)
+	return label
+}
+
+func (em *EndpointMatcher) countOverflow(label string) {
+	if em.maxPatterns > 0 && label == em.overflowLabel {
+		em.overflowTotal.Inc()
 	}
-	if node == c.tail {
-		c.tail = node.prev
+}
+
+// resolve runs the rules against the request, bypassing the cache.
+// Callers must hold the rules lock
+func (em *EndpointMatcher) resolve(req RequestInfo) endpointResult {
+	if rule := em.rules.lookup(req); rule != nil {
+		group := rule.group
+		if group == "" {
+			group = em.defaultGroup
+		}
+		return endpointResult{label: rule.label(req.Path, em.captureNormalizer), buckets: rule.buckets, group: group}
 	}
 
-	// Move to front
-	node.prev = nil
-	node.next = c.head
-	c.head.prev = node
-	c.head = node
+	// No match - normalize the path or use fallback
+	if em.normalizer != nil {
+		return endpointResult{label: em.normalizer.normalize(req.Path), group: em.defaultGroup}
+	}
+	return endpointResult{label: em.fallback, group: em.defaultGroup}
 }
 
-func (c *endpointCache) removeTail() {
-	if c.tail == nil {
-		return
+// admit enforces max_patterns. Labels handed out before the limit was hit
+// keep being returned, new ones collapse into the overflow label.
+// The fallback and exempt labels are always admitted and do not count towards the limit.
+func (em *EndpointMatcher) admit(label string) string {
+	if em.maxPatterns <= 0 || label == em.fallback {
+		return label
+	}
+	if _, ok := em.exempt[label]; ok {
+		return label
 	}
 
-	delete(c.items, c.tail.key)
+	em.labelsMu.RLock()
+	_, ok := em.labels[label]
+	em.labelsMu.RUnlock()
+	if ok {
+		return label
+	}
 
-	if c.tail.prev != nil {
-		c.tail.prev.next = nil
-		c.tail = c.tail.prev
-	} else {
-		c.head = nil
-		c.tail = nil
+	em.labelsMu.Lock()
+	defer em.labelsMu.Unlock()
+
+	if _, ok = em.labels[label]; ok {
+		return label
 	}
+	if len(em.labels) >= em.maxPatterns {
+		return em.overflowLabel
+	}
+
+	em.labels[label] = struct{}{}
+	return label
 }
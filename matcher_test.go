@@ -0,0 +1,129 @@
+package prometheus
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestMatcher(t *testing.T, config EndpointPatternsConfig) *EndpointMatcher {
+	t.Helper()
+
+	config.Enabled = true
+	if config.CacheSize == 0 {
+		config.CacheSize = 100
+	}
+	em, err := NewEndpointMatcher(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return em
+}
+
+func TestMatcherMaxPatterns(t *testing.T) {
+	em := newTestMatcher(t, EndpointPatternsConfig{
+		MaxPatterns: 2,
+		Rules: []PatternRule{
+			{Pattern: "^/a$", Name: "a"},
+			{Pattern: "^/b$", Name: "b"},
+			{Pattern: "^/c$", Name: "c"},
+		},
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/a", "a"},
+		{"/b", "b"},
+		{"/c", "__overflow__"},
+		// labels admitted before the limit keep their name
+		{"/a", "a"},
+		// the fallback never counts towards the limit
+		{"/unknown", "other"},
+		{"/c", "__overflow__"},
+	}
+	for _, tt := range tests {
+		if got := em.Match(RequestInfo{Path: tt.path}); got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	if got := testutil.ToFloat64(em.overflowTotal); got != 2 {
+		t.Errorf("endpoint_overflow_total = %v, want 2", got)
+	}
+}
+
+func TestMatcherMaxPatternsRouteNames(t *testing.T) {
+	em := newTestMatcher(t, EndpointPatternsConfig{MaxPatterns: 1, OverflowLabel: "too_many"})
+
+	if got := em.Label("users.show"); got != "users.show" {
+		t.Errorf("Label(users.show) = %q", got)
+	}
+	if got := em.Label("users.list"); got != "too_many" {
+		t.Errorf("Label(users.list) = %q, want the overflow label", got)
+	}
+	if got := testutil.ToFloat64(em.overflowTotal); got != 1 {
+		t.Errorf("endpoint_overflow_total = %v, want 1", got)
+	}
+}
+
+func TestMatcherUnlimitedPatterns(t *testing.T) {
+	em := newTestMatcher(t, EndpointPatternsConfig{
+		AutoNormalize: AutoNormalizeConfig{Enabled: true},
+	})
+
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		if got := em.Match(RequestInfo{Path: path}); got != path {
+			t.Errorf("Match(%q) = %q without a limit", path, got)
+		}
+	}
+	if got := testutil.ToFloat64(em.overflowTotal); got != 0 {
+		t.Errorf("endpoint_overflow_total = %v, want 0", got)
+	}
+}
+
+func TestMatcherScopedRules(t *testing.T) {
+	em := newTestMatcher(t, EndpointPatternsConfig{
+		Rules: []PatternRule{
+			{Pattern: "^/items$", Name: "items_create", Methods: []string{"POST"}},
+			{Pattern: "^/items$", Name: "items_admin", Host: "Admin.Example.com"},
+			{Pattern: "^/items$", Name: "items"},
+		},
+	})
+
+	tests := []struct {
+		method string
+		host   string
+		want   string
+	}{
+		{"POST", "shop.example.com", "items_create"},
+		{"GET", "admin.example.com:8080", "items_admin"},
+		{"GET", "ADMIN.example.com", "items_admin"},
+		{"GET", "shop.example.com", "items"},
+		// the cache must not hand out the result of another method or host
+		{"POST", "shop.example.com", "items_create"},
+		{"GET", "admin.example.com", "items_admin"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://"+tt.host+"/items", nil)
+		if got := em.Match(newRequestInfo(r)); got != tt.want {
+			t.Errorf("Match(%s %s) = %q, want %q", tt.method, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherUnscopedCacheKey(t *testing.T) {
+	em := newTestMatcher(t, EndpointPatternsConfig{Rules: []PatternRule{{Pattern: "^/a$", Name: "a"}}})
+	if em.scoped.Load() {
+		t.Fatal("rules without methods or hosts are scoped")
+	}
+
+	em.Match(RequestInfo{Method: "GET", Host: "one", Path: "/a"})
+	em.Match(RequestInfo{Method: "POST", Host: "two", Path: "/a"})
+	if st := em.cache.stats(); st.size != 1 || st.hits != 1 {
+		t.Errorf("cache size %d, hits %d; want one entry shared by methods and hosts", st.size, st.hits)
+	}
+}
@@ -0,0 +1,142 @@
+package prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// namePart is a piece of a rule name: either literal text or a reference to
+// a capture group (regex rules) or placeholder (route rules)
+type namePart struct {
+	literal string
+
+	// ref is the reference as written, empty for literal parts
+	ref       string
+	index     int
+	normalize bool
+}
+
+// parseNameTemplate splits a rule name into literal text and references:
+// $1, $name, ${name} and ${name:norm}, where :norm replaces the captured value
+// with auto-normalization placeholders (e.g. :num). $$ is a literal dollar sign,
+// as is a '$' followed by neither a reference nor '{', e.g. in "US$".
+// Returns nil parts for names without references
+func parseNameTemplate(name string) ([]namePart, error) {
+	if !strings.Contains(name, "$") {
+		return nil, nil
+	}
+
+	var parts []namePart
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			parts = append(parts, namePart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] != '$' {
+			literal.WriteByte(name[i])
+			continue
+		}
+
+		rest := name[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "$"):
+			literal.WriteByte('$')
+			i++
+
+		case strings.HasPrefix(rest, "{"):
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("name %q: unterminated reference", name)
+			}
+			ref, modifier, _ := strings.Cut(rest[1:end], ":")
+			if !isIdentifier(ref) {
+				return nil, fmt.Errorf("name %q: invalid reference %q", name, rest[:end+1])
+			}
+			if modifier != "" && modifier != "norm" {
+				return nil, fmt.Errorf("name %q: unknown modifier %q, only \"norm\" is supported", name, modifier)
+			}
+			flush()
+			parts = append(parts, namePart{ref: ref, normalize: modifier == "norm"})
+			i += end + 1
+
+		default:
+			n := 0
+			for n < len(rest) && isIdentifierByte(rest[n]) {
+				n++
+			}
+			if n == 0 {
+				literal.WriteByte('$')
+				continue
+			}
+			flush()
+			parts = append(parts, namePart{ref: rest[:n]})
+			i += n
+		}
+	}
+	flush()
+
+	return parts, nil
+}
+
+// bindNameTemplate resolves references to capture indexes. Numeric references
+// are used as is, named ones are looked up with indexOf (-1 if unknown)
+func bindNameTemplate(name string, parts []namePart, groups int, indexOf func(string) int) error {
+	for i := range parts {
+		ref := parts[i].ref
+		if ref == "" {
+			continue
+		}
+
+		index, err := strconv.Atoi(ref)
+		if err != nil {
+			index = indexOf(ref)
+		}
+		if index < 0 || index > groups {
+			return fmt.Errorf("name %q: reference %q does not match any capture group", name, ref)
+		}
+		parts[i].index = index
+	}
+	return nil
+}
+
+// expandName builds the label from the captured values. captures[0] is the whole path
+func expandName(parts []namePart, captures []string, normalizer *pathNormalizer) string {
+	var sb strings.Builder
+	for _, part := range parts {
+		if part.ref == "" {
+			sb.WriteString(part.literal)
+			continue
+		}
+
+		value := ""
+		if part.index < len(captures) {
+			value = captures[part.index]
+		}
+		if part.normalize {
+			value = normalizer.normalizeValue(value)
+		}
+		sb.WriteString(value)
+	}
+	return sb.String()
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isIdentifierByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,106 @@
+package prometheus
+
+import (
+	"testing"
+)
+
+func TestParseNameTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		want []namePart
+	}{
+		{"/users/:id", nil},
+		{"$1", []namePart{{ref: "1"}}},
+		{"/api/$version/users", []namePart{{literal: "/api/"}, {ref: "version"}, {literal: "/users"}}},
+		{"${kind}s", []namePart{{ref: "kind"}, {literal: "s"}}},
+		{"/files/${file:norm}", []namePart{{literal: "/files/"}, {ref: "file", normalize: true}}},
+		{"$$$1", []namePart{{literal: "$"}, {ref: "1"}}},
+		// a dollar sign not followed by a reference is literal
+		{"US$", []namePart{{literal: "US$"}}},
+		{"cost$/$1", []namePart{{literal: "cost$/"}, {ref: "1"}}},
+		{"$-$ $", []namePart{{literal: "$-$ $"}}},
+	}
+
+	for _, tt := range tests {
+		parts, err := parseNameTemplate(tt.name)
+		if err != nil {
+			t.Errorf("parseNameTemplate(%q): %v", tt.name, err)
+			continue
+		}
+		if len(parts) != len(tt.want) {
+			t.Errorf("parseNameTemplate(%q) = %+v, want %+v", tt.name, parts, tt.want)
+			continue
+		}
+		for i := range parts {
+			if parts[i] != tt.want[i] {
+				t.Errorf("parseNameTemplate(%q) = %+v, want %+v", tt.name, parts, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseNameTemplateErrors(t *testing.T) {
+	for _, name := range []string{
+		"${kind",
+		"${}",
+		"${a-b}",
+		"${kind:upper}",
+	} {
+		if _, err := parseNameTemplate(name); err == nil {
+			t.Errorf("parseNameTemplate(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestNameTemplateRules(t *testing.T) {
+	em := newTestMatcher(t, EndpointPatternsConfig{
+		Rules: []PatternRule{
+			{Pattern: "^/api/(v[0-9]+)/users/[0-9]+$", Name: "/api/$1/users/:id"},
+			{Pattern: "^/api/(?P<version>v[0-9]+)/orders$", Name: "/api/${version}/orders"},
+			{Route: "/downloads/{kind:alpha}/*file", Name: "/downloads/$kind/${file:norm}"},
+			{Route: "/prices/{currency:alpha}", Name: "/prices/US$"},
+			{Route: "/costs/{id:int}", Name: "cost$$"},
+		},
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v2/users/42", "/api/v2/users/:id"},
+		{"/api/v10/orders", "/api/v10/orders"},
+		{"/downloads/reports/2024/123e4567-e89b-12d3-a456-426614174000", "/downloads/reports/:num/:uuid"},
+		{"/prices/eur", "/prices/US$"},
+		{"/costs/7", "cost$"},
+	}
+	for _, tt := range tests {
+		if got := em.Match(RequestInfo{Method: "GET", Path: tt.path}); got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNameTemplateUnknownReference(t *testing.T) {
+	rules := []PatternRule{
+		{Pattern: "^/api/(v[0-9]+)$", Name: "$2"},
+		{Pattern: "^/api/(?P<version>v[0-9]+)$", Name: "$ver"},
+		{Route: "/users/{id:int}", Name: "/users/$name"},
+	}
+	for _, rule := range rules {
+		if _, err := compileRule(rule); err == nil {
+			t.Errorf("rule %+v compiled, want an unknown reference error", rule)
+		}
+	}
+}
+
+func TestStaticNameWithDollar(t *testing.T) {
+	c, err := compileRule(PatternRule{Pattern: "^/pay$", Name: "pay$$US$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// literal dollar signs do not make the name a template
+	if c.nameParts != nil || c.name != "pay$US$" {
+		t.Errorf("name %q, parts %+v; want static pay$US$", c.name, c.nameParts)
+	}
+}
@@ -0,0 +1,197 @@
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pathDetector recognizes ID-like path segments and names the placeholder
+// that replaces them
+type pathDetector struct {
+	placeholder string
+	match       func(string) bool
+}
+
+// pathDetectors lists the available detectors. Order matters: the first
+// detector accepting a segment wins, so stricter ones come first.
+var pathDetectors = []struct {
+	name     string
+	detector pathDetector
+}{
+	{"uuid", pathDetector{":uuid", isUUID}},
+	{"date", pathDetector{":date", isDate}},
+	{"email", pathDetector{":email", isEmail}},
+	{"num", pathDetector{":num", isDigits}},
+	{"hex", pathDetector{":hex", isHexToken}},
+	{"base64", pathDetector{":base64", isBase64Token}},
+}
+
+// pathNormalizer replaces ID-like segments of unmatched paths with placeholders,
+// e.g. /orders/8812/items/ab12cd34ef becomes /orders/:num/items/:hex
+type pathNormalizer struct {
+	detectors []pathDetector
+	maxDepth  int
+}
+
+// newPathNormalizer builds a normalizer from configuration. Returns nil if disabled
+func newPathNormalizer(config AutoNormalizeConfig) (*pathNormalizer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	enabled := make(map[string]bool, len(config.Detectors))
+	for _, name := range config.Detectors {
+		if !knownDetector(name) {
+			return nil, fmt.Errorf("auto_normalize: unknown detector %q", name)
+		}
+		enabled[name] = true
+	}
+
+	detectors := make([]pathDetector, 0, len(pathDetectors))
+	for _, d := range pathDetectors {
+		if len(enabled) == 0 || enabled[d.name] {
+			detectors = append(detectors, d.detector)
+		}
+	}
+
+	return &pathNormalizer{
+		detectors: detectors,
+		maxDepth:  config.MaxDepth,
+	}, nil
+}
+
+// newDefaultNormalizer returns a normalizer with all detectors and no depth limit
+func newDefaultNormalizer() *pathNormalizer {
+	detectors := make([]pathDetector, 0, len(pathDetectors))
+	for _, d := range pathDetectors {
+		detectors = append(detectors, d.detector)
+	}
+	return &pathNormalizer{detectors: detectors}
+}
+
+func knownDetector(name string) bool {
+	for _, d := range pathDetectors {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize returns the path with ID-like segments replaced. Segments beyond
+// maxDepth are collapsed into a single "..." segment.
+func (n *pathNormalizer) normalize(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return path
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(path))
+
+	rest := path[1:]
+	for depth := 0; ; depth++ {
+		if n.maxDepth > 0 && depth == n.maxDepth {
+			sb.WriteString("/...")
+			break
+		}
+
+		part, tail, more := strings.Cut(rest, "/")
+		sb.WriteByte('/')
+		sb.WriteString(n.segment(part))
+
+		if !more {
+			break
+		}
+		rest = tail
+	}
+
+	return sb.String()
+}
+
+// normalizeValue replaces ID-like segments of a captured value, which may span
+// several segments. The depth limit does not apply
+func (n *pathNormalizer) normalizeValue(value string) string {
+	parts := strings.Split(value, "/")
+	for i := range parts {
+		parts[i] = n.segment(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+func (n *pathNormalizer) segment(part string) string {
+	if part == "" {
+		return part
+	}
+	for _, d := range n.detectors {
+		if d.match(part) {
+			return d.placeholder
+		}
+	}
+	return part
+}
+
+// isDate accepts ISO-8601 dates, optionally followed by a time: 2024-01-31, 2024-01-31T10:00:00Z
+func isDate(s string) bool {
+	if len(s) < 10 {
+		return false
+	}
+	for i := 0; i < 10; i++ {
+		switch i {
+		case 4, 7:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if s[i] < '0' || s[i] > '9' {
+				return false
+			}
+		}
+	}
+	return len(s) == 10 || s[10] == 'T'
+}
+
+// isEmail is a loose check: something@domain.tld without path separators
+func isEmail(s string) bool {
+	local, domain, ok := strings.Cut(s, "@")
+	if !ok || local == "" || strings.ContainsAny(domain, "@/") {
+		return false
+	}
+	dot := strings.LastIndexByte(domain, '.')
+	return dot > 0 && dot < len(domain)-1
+}
+
+// isHexToken accepts hashes and hex ids. Requires at least 8 characters and
+// one digit so that words like "deadbeef" or "facade" stay intact.
+func isHexToken(s string) bool {
+	return len(s) >= 8 && isHex(s) && strings.ContainsAny(s, "0123456789")
+}
+
+// isBase64Token accepts standard and URL-safe base64 tokens of 16+ characters
+// mixing letter cases and digits, which rules out ordinary words and slugs
+func isBase64Token(s string) bool {
+	if len(s) < 16 {
+		return false
+	}
+
+	var upper, lower, digit bool
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c == '+' || c == '_' || c == '-':
+		case c == '=':
+			if i < len(s)-2 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return upper && lower && digit
+}
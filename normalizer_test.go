@@ -0,0 +1,88 @@
+package prometheus
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	n, err := newPathNormalizer(AutoNormalizeConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/users", "/users"},
+		{"/users/8812", "/users/:num"},
+		{"/orders/123e4567-e89b-12d3-a456-426614174000/items", "/orders/:uuid/items"},
+		{"/reports/2024-01-31", "/reports/:date"},
+		{"/reports/2024-01-31T10:00:00Z", "/reports/:date"},
+		{"/reports/2024-1-31", "/reports/2024-1-31"},
+		{"/users/jane@example.com", "/users/:email"},
+		{"/users/jane@localhost", "/users/jane@localhost"},
+		{"/blobs/ab12cd34ef", "/blobs/:hex"},
+		// words made of hex letters stay intact
+		{"/pages/deadbeef", "/pages/deadbeef"},
+		{"/pages/facade", "/pages/facade"},
+		{"/tokens/dGhpcyBpcyBhIFRlc3Q9", "/tokens/:base64"},
+		{"/posts/my-first-blog-post-2024", "/posts/my-first-blog-post-2024"},
+		{"/a//b/", "/a//b/"},
+	}
+
+	for _, tt := range tests {
+		if got := n.normalize(tt.path); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDetectorsAndDepth(t *testing.T) {
+	n, err := newPathNormalizer(AutoNormalizeConfig{Enabled: true, Detectors: []string{"num"}, MaxDepth: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/users/1/orders", "/users/:num/orders"},
+		{"/users/1/orders/2/items", "/users/:num/orders/..."},
+		// only enabled detectors apply
+		{"/blobs/ab12cd34ef", "/blobs/ab12cd34ef"},
+	}
+	for _, tt := range tests {
+		if got := n.normalize(tt.path); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNewPathNormalizer(t *testing.T) {
+	n, err := newPathNormalizer(AutoNormalizeConfig{Enabled: false})
+	if err != nil || n != nil {
+		t.Errorf("disabled normalizer = %v, %v; want nil, nil", n, err)
+	}
+
+	if _, err := newPathNormalizer(AutoNormalizeConfig{Enabled: true, Detectors: []string{"ssn"}}); err == nil {
+		t.Error("unknown detector accepted")
+	}
+}
+
+func TestMatcherAutoNormalize(t *testing.T) {
+	em := newTestMatcher(t, EndpointPatternsConfig{
+		Rules:         []PatternRule{{Pattern: "^/health$", Name: "health"}},
+		AutoNormalize: AutoNormalizeConfig{Enabled: true},
+	})
+
+	for path, want := range map[string]string{
+		"/health":       "health",
+		"/users/42":     "/users/:num",
+		"/users/42/foo": "/users/:num/foo",
+	} {
+		if got := em.Match(RequestInfo{Path: path}); got != want {
+			t.Errorf("Match(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -2,9 +2,14 @@ package prometheus
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"runtime"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -35,6 +40,36 @@ type Plugin struct {
 	cfg             Configurer
 	config          *Config
 	endpointMatcher *EndpointMatcher
+	routeHeader     *routeHeader
+	hosts           map[string]struct{}
+	exclude         *excludeFilter
+	upstream        *upstreamQueue
+
+	// workerStartHeader is the canonical name of the worker start header, empty if not used
+	workerStartHeader string
+
+	// Worker pool of the http plugin, see CollectWorkers
+	workersMu       sync.Mutex
+	informer        Informer
+	exportedWorkers map[string]string
+
+	// workerStats is the last state reported by the informer, nil until then
+	workerStats atomic.Pointer[WorkerPoolStats]
+
+	// Worker utilization estimated from in-flight requests when no informer is collected
+	poolWorkers int
+	inflight    *inflightTracker
+
+	// Endpoint rules hot reload
+	reloadMu      sync.Mutex
+	rulesFileStat rulesFileState
+	rulesReloads  *prometheus.CounterVec
+
+	excludedRequests prometheus.Counter
+	panics           prometheus.Counter
+	writeErrors      *prometheus.CounterVec
+	clientAborts     *prometheus.CounterVec
+	abortElapsed     *prometheus.HistogramVec
 
 	// Existing metrics
 	queueSize       prometheus.Gauge
@@ -45,23 +80,39 @@ type Plugin struct {
 
 	// NEW: Phase 1 metrics - Performance breakdown
 	queueTime      *prometheus.HistogramVec
-	processingTime *prometheus.HistogramVec
+	processingTime *profiledHistogram
+
+	upstreamQueueTime *prometheus.HistogramVec
+	timeToFirstByte   *profiledHistogram
+
+	// Hijacked connections, e.g. WebSockets
+	upgrades *upgradeTracker
+
+	// Streamed responses, e.g. Server-Sent Events
+	streams *streamTracker
 
 	// NEW: Phase 1 metrics - Request/Response sizes
 	requestSize  *prometheus.HistogramVec
+	declaredSize *prometheus.HistogramVec
+	unconsumed   *prometheus.CounterVec
 	responseSize *prometheus.HistogramVec
 
 	// NEW: Phase 1 metrics - Endpoint-level tracking
 	requestsByEndpoint *prometheus.CounterVec
-	durationByEndpoint *prometheus.HistogramVec
+	durationByEndpoint *profiledHistogram
 
 	// NEW: Phase 1 metrics - Error classification
 	errorsByType *prometheus.CounterVec
 
 	// NEW: Phase 1 metrics - Worker pool health
-	activeWorkers     prometheus.Gauge
-	idleWorkers       prometheus.Gauge
-	workerUtilization prometheus.Gauge
+	activeWorkers     prometheus.GaugeFunc
+	idleWorkers       prometheus.GaugeFunc
+	workerUtilization prometheus.GaugeFunc
+
+	// Per-worker gauges labelled by PID
+	workerMemory *prometheus.GaugeVec
+	workerJobs   *prometheus.GaugeVec
+	workerState  *prometheus.GaugeVec
 }
 
 func (p *Plugin) Init(cfg Configurer) error {
@@ -78,6 +129,20 @@ func (p *Plugin) Init(cfg Configurer) error {
 		}
 	}
 
+	// The http pool size is the base of the in-flight utilization estimate
+	if cfg != nil && cfg.Has(httpConfigKey) {
+		httpCfg := &httpPoolConfig{}
+		if err := cfg.UnmarshalKey(httpConfigKey, httpCfg); err != nil {
+			return err
+		}
+		p.poolWorkers = int(httpCfg.Pool.NumWorkers)
+	}
+
+	return p.init()
+}
+
+// init builds the plugin state from p.config
+func (p *Plugin) init() error {
 	// Initialize writers pool
 	p.writersPool = sync.Pool{
 		New: func() any {
@@ -89,12 +154,76 @@ func (p *Plugin) Init(cfg Configurer) error {
 
 	p.stopCh = make(chan struct{}, 1)
 
-	// Initialize endpoint matcher
+	// Initialize endpoint matcher, rules from the rules file are appended to the inline ones
+	patterns := p.config.EndpointPatterns
+	if patterns.Enabled && patterns.RulesFile != "" {
+		fileRules, stat, err := loadRulesFile(patterns.RulesFile)
+		if err != nil {
+			return err
+		}
+		patterns.Rules = append(slices.Clone(patterns.Rules), fileRules...)
+		p.rulesFileStat = stat
+	}
+
+	for name, buckets := range p.config.BucketProfiles {
+		if err := validateBuckets("bucket profile "+name, buckets); err != nil {
+			return err
+		}
+	}
+	if err := validateBuckets("queue_time_buckets", p.config.QueueTimeBuckets); err != nil {
+		return err
+	}
+	if err := validateBuckets("processing_time_buckets", p.config.ProcessingTimeBuckets); err != nil {
+		return err
+	}
+	if err := validateBuckets("upgraded_connection_buckets", p.config.UpgradedConnectionBuckets); err != nil {
+		return err
+	}
+	if err := validateBuckets("streaming.lifetime_buckets", p.config.Streaming.LifetimeBuckets); err != nil {
+		return err
+	}
+	if err := checkBucketProfiles(patterns.Rules, p.config.BucketProfiles); err != nil {
+		return err
+	}
+	for group, profile := range p.config.GroupBuckets {
+		if _, ok := p.config.BucketProfiles[profile]; !ok {
+			return fmt.Errorf("group %q: unknown bucket profile %q", group, profile)
+		}
+	}
+
 	var err error
-	p.endpointMatcher, err = NewEndpointMatcher(p.config.EndpointPatterns)
+	p.endpointMatcher, err = NewEndpointMatcher(patterns)
+	if err != nil {
+		return err
+	}
+
+	p.routeHeader, err = newRouteHeader(p.config.RouteHeader)
+	if err != nil {
+		return err
+	}
+
+	if p.config.CollectQueueTime && p.config.WorkerStartHeader != "" {
+		p.workerStartHeader = http.CanonicalHeaderKey(p.config.WorkerStartHeader)
+	}
+
+	p.upstream, err = newUpstreamQueue(p.config.UpstreamQueueTime)
+	if err != nil {
+		return err
+	}
+
+	p.exclude, err = newExcludeFilter(p.config.Exclude)
 	if err != nil {
 		return err
 	}
+	if p.exclude != nil {
+		// excluded endpoints are never recorded, so they must not use up max_patterns
+		p.endpointMatcher.exempt = p.exclude.endpoints
+	}
+
+	p.hosts = make(map[string]struct{}, len(p.config.HostLabel.Hosts))
+	for _, host := range p.config.HostLabel.Hosts {
+		p.hosts[NormalizeHost(host)] = struct{}{}
+	}
 
 	// Initialize existing metrics
 	p.queueSize = prometheus.NewGauge(prometheus.GaugeOpts{
@@ -131,6 +260,48 @@ func (p *Plugin) Init(cfg Configurer) error {
 		Help:      "Uptime in seconds",
 	}, nil)
 
+	p.rulesReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "endpoint_rules_reload_total",
+		Help:      "Total number of endpoint rules reloads by result (success, failure).",
+	}, []string{"result"})
+
+	p.excludedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "excluded_requests_total",
+		Help:      "Total number of requests excluded from metrics collection.",
+	})
+
+	p.panics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panics_total",
+		Help:      "Total number of requests whose handler panicked, recorded with status 500.",
+	})
+
+	p.writeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "write_errors_total",
+		Help:      "Total number of responses that failed to be written, by reason (broken_pipe, connection_reset, timeout, other).",
+	}, []string{"reason"})
+
+	if p.config.CollectAborts {
+		p.clientAborts = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "client_aborts_total",
+			Help:      "Total number of requests the client went away from before the response was complete.",
+		}, []string{"endpoint"})
+
+		p.abortElapsed = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "client_abort_elapsed_seconds",
+				Help:      "Time from request arrival until the client went away.",
+				Buckets:   p.config.DurationBuckets,
+			},
+			[]string{"endpoint"},
+		)
+	}
+
 	// Initialize NEW metrics - Performance breakdown
 	if p.config.CollectQueueTime {
 		p.queueTime = prometheus.NewHistogramVec(
@@ -138,19 +309,51 @@ func (p *Plugin) Init(cfg Configurer) error {
 				Namespace: namespace,
 				Name:      "queue_time_seconds",
 				Help:      "Time request spent waiting in queue before being picked up by a worker.",
-				Buckets:   p.config.DurationBuckets,
+				Buckets:   orDefault(p.config.QueueTimeBuckets, p.config.DurationBuckets),
 			},
 			[]string{"method", "endpoint"},
 		)
 
-		p.processingTime = prometheus.NewHistogramVec(
+		p.processingTime = newProfiledHistogram(
 			prometheus.HistogramOpts{
 				Namespace: namespace,
 				Name:      "processing_time_seconds",
 				Help:      "Time spent processing the request by PHP worker.",
+				Buckets:   orDefault(p.config.ProcessingTimeBuckets, p.config.DurationBuckets),
+			},
+			[]string{"method", "endpoint"},
+			p.config.BucketProfiles,
+		)
+	}
+
+	if p.upstream != nil {
+		p.upstreamQueueTime = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "upstream_queue_time_seconds",
+				Help:      "Time request spent between a trusted proxy receiving it and its arrival at RoadRunner.",
+				Buckets:   orDefault(p.config.QueueTimeBuckets, p.config.DurationBuckets),
+			},
+			[]string{"method", "endpoint"},
+		)
+	}
+
+	p.upgrades = newUpgradeTracker(p.config.UpgradedConnectionBuckets)
+
+	if p.config.Streaming.Enabled {
+		p.streams = newStreamTracker(p.config.Streaming, p.config.DurationBuckets, p.writerEndpoint)
+	}
+
+	if p.config.CollectTTFB {
+		p.timeToFirstByte = newProfiledHistogram(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "time_to_first_byte_seconds",
+				Help:      "Time from request arrival until the response headers were sent.",
 				Buckets:   p.config.DurationBuckets,
 			},
 			[]string{"method", "endpoint"},
+			p.config.BucketProfiles,
 		)
 	}
 
@@ -160,12 +363,31 @@ func (p *Plugin) Init(cfg Configurer) error {
 			prometheus.HistogramOpts{
 				Namespace: namespace,
 				Name:      "request_size_bytes",
-				Help:      "HTTP request body size in bytes.",
+				Help:      "HTTP request body size in bytes, as read from the body.",
+				Buckets:   p.config.SizeBuckets,
+			},
+			[]string{"method", "endpoint"},
+		)
+
+		p.declaredSize = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "request_declared_size_bytes",
+				Help:      "HTTP request body size in bytes as declared by the Content-Length header.",
 				Buckets:   p.config.SizeBuckets,
 			},
 			[]string{"method", "endpoint"},
 		)
 
+		p.unconsumed = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "request_body_unconsumed_total",
+				Help:      "Total number of requests whose body was not read to the end.",
+			},
+			[]string{"method", "endpoint"},
+		)
+
 		p.responseSize = prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: namespace,
@@ -184,17 +406,18 @@ func (p *Plugin) Init(cfg Configurer) error {
 			Name:      "requests_by_endpoint_total",
 			Help:      "Total number of HTTP requests by endpoint pattern.",
 		},
-		[]string{"method", "endpoint", "status"},
+		p.endpointLabelNames("method", "endpoint", "status"),
 	)
 
-	p.durationByEndpoint = prometheus.NewHistogramVec(
+	p.durationByEndpoint = newProfiledHistogram(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
 			Name:      "duration_by_endpoint_seconds",
 			Help:      "HTTP request duration by endpoint pattern.",
 			Buckets:   p.config.DurationBuckets,
 		},
-		[]string{"method", "endpoint"},
+		p.endpointLabelNames("method", "endpoint"),
+		p.config.BucketProfiles,
 	)
 
 	// Initialize NEW metrics - Error classification
@@ -204,28 +427,59 @@ func (p *Plugin) Init(cfg Configurer) error {
 			Name:      "errors_total",
 			Help:      "Total number of HTTP errors classified by type.",
 		},
-		[]string{"type", "endpoint", "status"},
+		p.endpointLabelNames("type", "endpoint", "status"),
 	)
 
-	// Initialize NEW metrics - Worker pool health
+	// Initialize NEW metrics - Worker pool health, read at scrape time
 	if p.config.CollectWorkerInfo {
-		p.activeWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		// RoadRunner starts one worker per CPU unless num_workers is set
+		workers := p.poolWorkers
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+		p.inflight = newInflightTracker(workers)
+
+		p.activeWorkers = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "active_workers",
 			Help:      "Number of workers currently processing requests.",
+		}, func() float64 {
+			return float64(p.workerPool().Active)
 		})
 
-		p.idleWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		p.idleWorkers = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "idle_workers",
 			Help:      "Number of idle workers available to process requests.",
+		}, func() float64 {
+			return float64(p.workerPool().Idle)
 		})
 
-		p.workerUtilization = prometheus.NewGauge(prometheus.GaugeOpts{
+		p.workerUtilization = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "worker_utilization_percent",
 			Help:      "Worker pool utilization percentage (0-100).",
+		}, func() float64 {
+			return p.workerPool().Utilization
 		})
+
+		p.workerMemory = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_memory_bytes",
+			Help:      "Memory usage of a worker in bytes.",
+		}, []string{"pid"})
+
+		p.workerJobs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_jobs_executed",
+			Help:      "Number of jobs a worker has executed since it started.",
+		}, []string{"pid"})
+
+		p.workerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_state",
+			Help:      "Current state of a worker, the series of the active state is 1.",
+		}, []string{"pid", "state"})
 	}
 
 	p.prop = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}, jprop.Jaeger{})
@@ -250,6 +504,16 @@ func (p *Plugin) Serve() chan error {
 		}
 	}()
 
+	// Refresh worker pool metrics from the collected informer
+	if p.config.CollectWorkerInfo {
+		go p.watchWorkers()
+	}
+
+	// Watch the endpoint rules file
+	if p.config.EndpointPatterns.Enabled && p.config.EndpointPatterns.RulesFile != "" {
+		go p.watchRulesFile()
+	}
+
 	return errCh
 }
 
@@ -260,6 +524,12 @@ func (p *Plugin) Stop(context.Context) error {
 
 func (p *Plugin) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every request occupies a worker, excluded ones included
+		if p.inflight != nil {
+			p.inflight.add(1)
+			defer p.inflight.add(-1)
+		}
+
 		// Handle OpenTelemetry tracing (existing logic)
 		if val, ok := r.Context().Value(rrcontext.OtelTracerNameKey).(string); ok {
 			tp := trace.SpanFromContext(r.Context()).TracerProvider()
@@ -273,6 +543,17 @@ func (p *Plugin) Middleware(next http.Handler) http.Handler {
 			r = r.WithContext(ctx)
 		}
 
+		// Excluded traffic is not recorded
+		info := newRequestInfo(r)
+		excluded, probe := p.excluded(r, info)
+		if excluded {
+			if p.config.Exclude.Count {
+				p.excludedRequests.Inc()
+			}
+			p.serveExcluded(next, w, r)
+			return
+		}
+
 		// Record arrival time
 		arrivalTime := time.Now()
 
@@ -281,88 +562,203 @@ func (p *Plugin) Middleware(next http.Handler) http.Handler {
 		defer p.putWriter(rrWriter)
 
 		rrWriter.arrivalTime = arrivalTime
-		rrWriter.queueStart = time.Now()
-		rrWriter.requestSize = r.ContentLength
-
-		// Track queue size
-		p.queueSize.Inc()
+		rrWriter.stream.req = r
+		rrWriter.probe = probe
 
-		// Processing starts when worker picks up request
-		rrWriter.processStart = time.Now()
+		// Count the request body bytes the handler reads
+		var body *requestBody
+		if p.config.CollectSizes {
+			body = wrapBody(r)
+		}
 
-		// Execute request
-		next.ServeHTTP(rrWriter, r)
+		// Time spent in front of RoadRunner, read before the worker sees the request
+		var upstreamWait time.Duration
+		upstreamOK := false
+		if p.upstream != nil {
+			upstreamWait, upstreamOK = p.upstream.queueTime(r, arrivalTime)
+		}
 
-		processEnd := time.Now()
+		// Track queue size
+		p.queueSize.Inc()
 
-		// Extract request metadata
-		endpoint := "other"
-		if p.config.EndpointPatterns.Enabled {
-			endpoint = p.endpointMatcher.Match(r.URL.Path)
-		}
-		method := r.Method
-		status := strconv.Itoa(rrWriter.code)
-
-		// Calculate timings
-		queueTime := rrWriter.processStart.Sub(rrWriter.queueStart)
-		processingTime := processEnd.Sub(rrWriter.processStart)
-		totalTime := processEnd.Sub(rrWriter.arrivalTime)
-
-		// Create label sets for metrics
-		endpointLabels := prometheus.Labels{
-			"method":   method,
-			"endpoint": endpoint,
+		// Watch for clients going away while the request is served
+		var abort *abortWatch
+		if p.config.CollectAborts {
+			abort = watchAbort(r.Context())
 		}
 
-		fullLabels := prometheus.Labels{
-			"method":   method,
-			"endpoint": endpoint,
-			"status":   status,
-		}
+		// Record the request once the handler returned. A panic is re-raised
+		// from here, the handler frames are still on the stack for its trace
+		defer func() {
+			recovered := recover()
+			panicked := recovered != nil && recovered != http.ErrAbortHandler
 
-		// Record existing metrics
-		p.requestCounter.With(prometheus.Labels{"status": status}).Inc()
-		p.requestDuration.With(prometheus.Labels{"status": status}).Observe(totalTime.Seconds())
+			processEnd := time.Now()
 
-		// Record NEW metrics - Performance breakdown
-		if p.config.CollectQueueTime {
-			p.queueTime.With(endpointLabels).Observe(queueTime.Seconds())
-			p.processingTime.With(endpointLabels).Observe(processingTime.Seconds())
-		}
+			var abortedAt time.Time
+			aborted := false
+			if abort != nil {
+				abortedAt, aborted = abort.done(processEnd)
+			}
 
-		// Record NEW metrics - Request/Response sizes
-		if p.config.CollectSizes {
-			if rrWriter.requestSize > 0 {
-				p.requestSize.With(endpointLabels).Observe(float64(rrWriter.requestSize))
+			// Strip internal headers even if the worker never wrote a response
+			rrWriter.captureHeaders()
+			rrWriter.stream.finish(rrWriter)
+
+			// Extract request metadata, streams resolved their endpoint on detection
+			result := rrWriter.stream.result
+			if !rrWriter.stream.detected {
+				result = p.writerEndpoint(rrWriter)
 			}
-			if rrWriter.bytesWritten > 0 {
-				p.responseSize.With(fullLabels).Observe(float64(rrWriter.bytesWritten))
+			endpoint := result.label
+			host := p.hostLabel(info.Host)
+			method := r.Method
+			code := statusCode(rrWriter, r, panicked)
+			status := strconv.Itoa(code)
+
+			// Upgraded connections live on after the handshake, their lifetime is
+			// recorded separately when they are closed
+			upgraded := code == http.StatusSwitchingProtocols
+			if rrWriter.conn != nil {
+				rrWriter.conn.resolve(endpoint)
 			}
-		}
 
-		// Record NEW metrics - Endpoint-level tracking
-		p.requestsByEndpoint.With(fullLabels).Inc()
-		p.durationByEndpoint.With(endpointLabels).Observe(totalTime.Seconds())
+			// The duration of streams is their lifetime rather than a response time
+			streamed := rrWriter.stream.detected
+			timed := !upgraded && !(streamed && p.config.Streaming.ExcludeFromDuration)
+
+			// Calculate timings, the worker reports when it picked the request up
+			totalTime := processEnd.Sub(rrWriter.arrivalTime)
+			queueTime, processingTime, split := splitAtWorkerStart(rrWriter.arrivalTime, processEnd, rrWriter.workerStart)
+
+			// Create label sets for metrics
+			endpointLabels := prometheus.Labels{
+				"method":   method,
+				"endpoint": endpoint,
+			}
 
-		// Record NEW metrics - Error classification
-		if isErrorStatus(rrWriter.code) {
-			errorType := string(classifyError(rrWriter.code, w.Header()))
-			p.errorsByType.With(prometheus.Labels{
-				"type":     errorType,
+			fullLabels := prometheus.Labels{
+				"method":   method,
 				"endpoint": endpoint,
 				"status":   status,
-			}).Inc()
-		}
+			}
 
-		// Handle no workers case (existing logic)
-		if w.Header().Get(noWorkers) == trueStr {
-			p.noFreeWorkers.With(nil).Inc()
-		}
+			// Record existing metrics
+			p.requestCounter.With(prometheus.Labels{"status": status}).Inc()
+			if timed {
+				p.requestDuration.With(prometheus.Labels{"status": status}).Observe(totalTime.Seconds())
+			}
+
+			// Record NEW metrics - Performance breakdown. Without a worker start
+			// timestamp the queue time is unknown and the whole request is processing.
+			// The queue wait does not depend on how long a stream or socket lives
+			if p.config.CollectQueueTime {
+				if split {
+					p.queueTime.With(endpointLabels).Observe(queueTime.Seconds())
+				} else {
+					processingTime = totalTime
+				}
+				if timed {
+					p.processingTime.observe(result.buckets, endpoint, endpointLabels, processingTime.Seconds())
+				}
+			}
+
+			// Requests that never wrote a response have no first byte
+			if p.config.CollectTTFB && !upgraded && !rrWriter.firstByte.IsZero() {
+				p.timeToFirstByte.observe(result.buckets, endpoint, endpointLabels, rrWriter.firstByte.Sub(rrWriter.arrivalTime).Seconds())
+			}
+
+			if streamed {
+				rrWriter.stream.observe(totalTime)
+			}
+
+			// Hijacked connections are no longer tied to the request context
+			if aborted && !upgraded {
+				p.clientAborts.WithLabelValues(endpoint).Inc()
+				p.abortElapsed.WithLabelValues(endpoint).Observe(abortedAt.Sub(rrWriter.arrivalTime).Seconds())
+			}
+
+			if rrWriter.writeErr != nil {
+				p.writeErrors.WithLabelValues(writeErrorReason(rrWriter.writeErr)).Inc()
+			}
+
+			if upstreamOK {
+				p.upstreamQueueTime.With(endpointLabels).Observe(upstreamWait.Seconds())
+			}
+
+			// Record NEW metrics - Request/Response sizes
+			if p.config.CollectSizes {
+				if body != nil {
+					if read := body.read.Load(); read > 0 {
+						p.requestSize.With(endpointLabels).Observe(float64(read))
+					}
+					if p.config.CollectDeclaredSize && body.declared > 0 {
+						p.declaredSize.With(endpointLabels).Observe(float64(body.declared))
+					}
+					if body.unconsumed() {
+						p.unconsumed.With(endpointLabels).Inc()
+					}
+				}
+				if rrWriter.bytesWritten > 0 {
+					p.responseSize.With(fullLabels).Observe(float64(rrWriter.bytesWritten))
+				}
+			}
+
+			// Record NEW metrics - Endpoint-level tracking
+			p.requestsByEndpoint.With(p.scopeLabels(fullLabels, result.group, host)).Inc()
+			if timed {
+				p.durationByEndpoint.observe(result.buckets, endpoint, p.scopeLabels(endpointLabels, result.group, host), totalTime.Seconds())
+			}
+
+			// Record NEW metrics - Error classification
+			if isErrorStatus(code) {
+				errorType := string(classifyError(code, w.Header()))
+				if panicked {
+					errorType = string(ErrorTypePanic)
+					p.panics.Inc()
+				}
+				p.errorsByType.With(p.scopeLabels(prometheus.Labels{
+					"type":     errorType,
+					"endpoint": endpoint,
+					"status":   status,
+				}, result.group, host)).Inc()
+			}
 
-		p.queueSize.Dec()
+			// Handle no workers case (existing logic)
+			if w.Header().Get(noWorkers) == trueStr {
+				p.noFreeWorkers.With(nil).Inc()
+			}
+
+			p.queueSize.Dec()
+
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+
+		next.ServeHTTP(rrWriter.responseWriter(), r)
 	})
 }
 
+// statusCode returns the status the request is recorded with
+func statusCode(w *writer, r *http.Request, panicked bool) int {
+	switch {
+	case panicked:
+		return http.StatusInternalServerError
+	case w.hijacked && w.code == -1:
+		// the handshake was written on the hijacked connection
+		return http.StatusSwitchingProtocols
+	case w.firstByte.IsZero() && errors.Is(r.Context().Err(), context.Canceled):
+		// the client went away before the response
+		return statusClientClosed
+	case w.code == -1:
+		// net/http sends 200 for handlers that wrote nothing
+		return http.StatusOK
+	default:
+		return w.code
+	}
+}
+
 func (p *Plugin) Name() string {
 	return pluginName
 }
@@ -379,6 +775,17 @@ func (p *Plugin) MetricsCollector() []prometheus.Collector {
 		p.requestsByEndpoint,
 		p.durationByEndpoint,
 		p.errorsByType,
+		p.panics,
+		p.writeErrors,
+	}
+	collectors = append(collectors, p.upgrades.collectors()...)
+
+	if p.streams != nil {
+		collectors = append(collectors, p.streams.collectors()...)
+	}
+
+	if p.config.CollectAborts {
+		collectors = append(collectors, p.clientAborts, p.abortElapsed)
 	}
 
 	// Add conditional metrics
@@ -386,20 +793,238 @@ func (p *Plugin) MetricsCollector() []prometheus.Collector {
 		collectors = append(collectors, p.queueTime, p.processingTime)
 	}
 
+	if p.upstream != nil {
+		collectors = append(collectors, p.upstreamQueueTime)
+	}
+
+	if p.config.CollectTTFB {
+		collectors = append(collectors, p.timeToFirstByte)
+	}
+
 	if p.config.CollectSizes {
-		collectors = append(collectors, p.requestSize, p.responseSize)
+		collectors = append(collectors, p.requestSize, p.responseSize, p.unconsumed)
+		if p.config.CollectDeclaredSize {
+			collectors = append(collectors, p.declaredSize)
+		}
 	}
 
 	if p.config.CollectWorkerInfo {
 		collectors = append(collectors, p.activeWorkers, p.idleWorkers, p.workerUtilization)
+		collectors = append(collectors, p.inflight)
+		if p.config.MaxWorkerSeries > 0 {
+			collectors = append(collectors, p.workerMemory, p.workerJobs, p.workerState)
+		}
+	}
+
+	if p.config.EndpointPatterns.Enabled && p.config.EndpointPatterns.MaxPatterns > 0 {
+		collectors = append(collectors, p.endpointMatcher.overflowTotal)
+	}
+
+	if p.exclude != nil && p.config.Exclude.Count {
+		collectors = append(collectors, p.excludedRequests)
+	}
+
+	if p.config.EndpointPatterns.Enabled {
+		collectors = append(collectors, p.rulesReloads)
+		collectors = append(collectors, p.endpointMatcher.cacheMetrics...)
 	}
 
 	return collectors
 }
 
+// watchRulesFile polls the rules file and reloads the rules when it changes
+func (p *Plugin) watchRulesFile() {
+	interval := p.config.EndpointPatterns.ReloadInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.checkRulesFile()
+		}
+	}
+}
+
+// checkRulesFile reloads the rules if the file changed since the last attempt
+func (p *Plugin) checkRulesFile() {
+	if rulesFileChanged(p.config.EndpointPatterns.RulesFile, p.currentRulesFileStat()) {
+		// failures are reported via rr_http_endpoint_rules_reload_total
+		_, _ = p.reloadRules()
+	}
+}
+
+func (p *Plugin) currentRulesFileStat() rulesFileState {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	return p.rulesFileStat
+}
+
+// reloadRules re-reads the rules file and swaps the matcher rules.
+// On failure the previous rules stay active. Returns the number of active rules
+func (p *Plugin) reloadRules() (int, error) {
+	patterns := p.config.EndpointPatterns
+	if !patterns.Enabled || patterns.RulesFile == "" {
+		return 0, errors.New("endpoint rules reload requires endpoint_patterns.enabled and endpoint_patterns.rules_file")
+	}
+
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	fileRules, stat, err := loadRulesFile(patterns.RulesFile)
+	if !stat.modTime.IsZero() {
+		// remember the version even if it is invalid, so it is not retried until changed
+		p.rulesFileStat = stat
+	}
+	if err == nil {
+		rules := append(slices.Clone(patterns.Rules), fileRules...)
+		if err = checkBucketProfiles(rules, p.config.BucketProfiles); err == nil {
+			err = p.endpointMatcher.Reload(rules)
+		}
+		if err == nil {
+			p.durationByEndpoint.repin()
+			if p.processingTime != nil {
+				p.processingTime.repin()
+			}
+			if p.timeToFirstByte != nil {
+				p.timeToFirstByte.repin()
+			}
+			p.rulesReloads.WithLabelValues("success").Inc()
+			return len(rules), nil
+		}
+	}
+
+	p.rulesReloads.WithLabelValues("failure").Inc()
+	return 0, err
+}
+
+// endpoint resolves the endpoint label, group and bucket profile. A route name
+// reported by the worker takes precedence, matcher rules are the fallback
+func (p *Plugin) endpoint(info RequestInfo, route string, probe *endpointProbe) endpointResult {
+	result := endpointResult{label: "other", group: p.endpointMatcher.defaultGroup}
+
+	if label, ok := p.routeLabel(route); ok {
+		result.label = p.endpointMatcher.Label(label)
+	} else if p.config.EndpointPatterns.Enabled {
+		if probe != nil {
+			result = p.endpointMatcher.settle(info, *probe)
+		} else {
+			result = p.endpointMatcher.matchResult(info)
+		}
+	}
+
+	if result.buckets == "" {
+		result.buckets = p.config.GroupBuckets[result.group]
+	}
+	return result
+}
+
+// writerEndpoint resolves the endpoint of the request served by the writer
+func (p *Plugin) writerEndpoint(w *writer) endpointResult {
+	return p.endpoint(newRequestInfo(w.stream.req), w.route, w.probe)
+}
+
+// excluded reports whether the request is left out of metrics collection.
+// Excluding by endpoint matches the rules without taking a max_patterns
+// slot, the returned probe completes the match when the request is recorded
+func (p *Plugin) excluded(r *http.Request, info RequestInfo) (bool, *endpointProbe) {
+	if p.exclude == nil {
+		return false, nil
+	}
+	if p.exclude.request(r) {
+		return true, nil
+	}
+	if len(p.exclude.endpoints) == 0 || !p.config.EndpointPatterns.Enabled {
+		return false, nil
+	}
+
+	probe := p.endpointMatcher.peek(info)
+	return p.exclude.endpoint(probe.result.label), &probe
+}
+
+// routeLabel returns the route name reported by the worker if it is accepted
+func (p *Plugin) routeLabel(route string) (string, bool) {
+	if p.routeHeader == nil {
+		return "", false
+	}
+	return p.routeHeader.label(route)
+}
+
+// endpointLabelNames returns label names of the endpoint-level metrics,
+// extended with the optional group and host labels
+func (p *Plugin) endpointLabelNames(names ...string) []string {
+	if p.config.GroupLabel {
+		names = append(names, "group")
+	}
+	if p.config.HostLabel.Enabled {
+		names = append(names, "host")
+	}
+	return names
+}
+
+// scopeLabels returns the labels extended with the group and host labels when
+// enabled. The passed labels are not modified
+func (p *Plugin) scopeLabels(labels prometheus.Labels, group, host string) prometheus.Labels {
+	if !p.config.GroupLabel && !p.config.HostLabel.Enabled {
+		return labels
+	}
+
+	scoped := make(prometheus.Labels, len(labels)+2)
+	for k, v := range labels {
+		scoped[k] = v
+	}
+	if p.config.GroupLabel {
+		scoped["group"] = group
+	}
+	if p.config.HostLabel.Enabled {
+		scoped["host"] = host
+	}
+	return scoped
+}
+
+// hostLabel maps the request host to its label value using the allowlist
+func (p *Plugin) hostLabel(host string) string {
+	if !p.config.HostLabel.Enabled || len(p.hosts) == 0 {
+		return host
+	}
+	if _, ok := p.hosts[host]; ok {
+		return host
+	}
+	return "other"
+}
+
+// serveExcluded serves a request left out of metrics collection. The
+// internal response headers are still stripped, nothing else is tracked
+func (p *Plugin) serveExcluded(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if p.routeHeader == nil && p.workerStartHeader == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	rrWriter := p.getWriter(w)
+	defer p.putWriter(rrWriter)
+
+	rrWriter.upgrades = nil
+	rrWriter.stream.tracker = nil
+
+	next.ServeHTTP(rrWriter.responseWriter(), r)
+	rrWriter.captureHeaders()
+}
+
 func (p *Plugin) getWriter(w http.ResponseWriter) *writer {
 	wr := p.writersPool.Get().(*writer)
 	wr.w = w
+	if p.routeHeader != nil {
+		wr.routeHeader = p.routeHeader.name
+	}
+	wr.workerStartHeader = p.workerStartHeader
+	wr.upgrades = p.upgrades
+	wr.stream.tracker = p.streams
 	return wr
 }
 
@@ -408,19 +1033,39 @@ func (p *Plugin) putWriter(w *writer) {
 	p.writersPool.Put(w)
 }
 
-// updateWorkerMetrics queries worker pool state and updates metrics
-// NOTE: This requires integration with HTTP plugin's worker pool
-// Implementation depends on RoadRunner's internal API for accessing worker pool state
+// watchWorkers refreshes the worker pool metrics until the plugin stops
+func (p *Plugin) watchWorkers() {
+	interval := p.config.WorkerInfoInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	// the informer replaces the estimate right away
+	p.updateWorkerMetrics()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.updateWorkerMetrics()
+		}
+	}
+}
+
+// updateWorkerMetrics queries worker pool state and updates metrics.
+// Worker state comes from the http plugin registered through CollectWorkers
 func (p *Plugin) updateWorkerMetrics() {
-	// TODO: Implement worker pool state access
-	// This requires extending the HTTP plugin to expose worker pool statistics
-	// or using RoadRunner's internal interfaces to query worker state
-	//
-	// Example pseudo-code:
-	// stats := p.getWorkerPoolStats()
-	// if stats != nil {
-	//     p.activeWorkers.Set(float64(stats.Active))
-	//     p.idleWorkers.Set(float64(stats.Idle))
-	//     p.workerUtilization.Set(stats.Utilization)
-	// }
+	stats, workers := p.workerPoolStats()
+	if stats == nil {
+		return
+	}
+
+	p.workerStats.Store(stats)
+
+	if p.config.MaxWorkerSeries > 0 {
+		p.updateWorkerGauges(workers)
+	}
 }
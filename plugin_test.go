@@ -0,0 +1,113 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestPlugin initializes a plugin with the default configuration changed by configure
+func newTestPlugin(t *testing.T, configure func(*Config)) *Plugin {
+	t.Helper()
+
+	p := &Plugin{config: DefaultConfig()}
+	if configure != nil {
+		configure(p.config)
+	}
+	if err := p.init(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// serveRequest runs the request through the middleware and the handler
+func serveRequest(p *Plugin, handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.Middleware(handler).ServeHTTP(rec, r)
+	return rec
+}
+
+// gather collects the plugin metrics by family name
+func gather(t *testing.T, p *Plugin) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(p.MetricsCollector()...)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+	return byName
+}
+
+// findMetric returns the series of the family having at least the given labels
+func findMetric(families map[string]*dto.MetricFamily, name string, labels map[string]string) *dto.Metric {
+	mf, ok := families[name]
+	if !ok {
+		return nil
+	}
+
+	for _, m := range mf.GetMetric() {
+		matched := 0
+		for _, lp := range m.GetLabel() {
+			if v, ok := labels[lp.GetName()]; ok && v == lp.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			return m
+		}
+	}
+	return nil
+}
+
+// metricValue returns the value of a counter or gauge series, or the sample
+// count of a histogram series. Missing series are -1
+func metricValue(families map[string]*dto.MetricFamily, name string, labels map[string]string) float64 {
+	m := findMetric(families, name, labels)
+	switch {
+	case m == nil:
+		return -1
+	case m.Counter != nil:
+		return m.GetCounter().GetValue()
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue()
+	case m.Histogram != nil:
+		return float64(m.GetHistogram().GetSampleCount())
+	default:
+		return -1
+	}
+}
+
+func TestMiddlewareRecordsRequest(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	rec := serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}, httptest.NewRequest(http.MethodPost, "/users", nil))
+
+	if rec.Code != http.StatusCreated || rec.Body.String() != "created" {
+		t.Fatalf("response %d %q", rec.Code, rec.Body.String())
+	}
+
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_request_total", map[string]string{"status": "201"}); v != 1 {
+		t.Errorf("request_total{status=201} = %v, want 1", v)
+	}
+	labels := map[string]string{"method": "POST", "endpoint": "other", "status": "201"}
+	if v := metricValue(families, "rr_http_requests_by_endpoint_total", labels); v != 1 {
+		t.Errorf("requests_by_endpoint_total = %v, want 1", v)
+	}
+	if v := metricValue(families, "rr_http_response_size_bytes", labels); v != 1 {
+		t.Errorf("response_size_bytes count = %v, want 1", v)
+	}
+}
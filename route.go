@@ -0,0 +1,280 @@
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// routeParamTypes maps placeholder types usable in route templates
+// (e.g. {id:int}) to the check applied to a single path segment
+var routeParamTypes = map[string]func(string) bool{
+	"":      func(s string) bool { return s != "" },
+	"int":   isDigits,
+	"uuid":  isUUID,
+	"hex":   isHex,
+	"alpha": isAlpha,
+	"alnum": isAlnum,
+	"slug":  isSlug,
+}
+
+// segmentKind describes how a route template segment matches a path segment
+type segmentKind int
+
+const (
+	segmentLiteral  segmentKind = iota // exact text, e.g. "users"
+	segmentParam                       // single typed placeholder, e.g. {id:int}
+	segmentCatchAll                    // remainder of the path, e.g. *file
+)
+
+type routeSegment struct {
+	kind    segmentKind
+	literal string
+	name    string
+	typ     string
+	check   func(string) bool
+}
+
+// routeTemplate is a parsed route template such as /api/users/{id:int}
+type routeTemplate struct {
+	template string
+	segments []routeSegment
+}
+
+// parseRoute parses a route template into its segments.
+// Supported syntax: literal segments, {name} or {name:type} placeholders
+// occupying a whole segment, and a trailing *name catch-all.
+func parseRoute(template string) (*routeTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("route %q: must start with '/'", template)
+	}
+
+	parts := strings.Split(template[1:], "/")
+	segments := make([]routeSegment, 0, len(parts))
+	names := make(map[string]struct{}, len(parts))
+
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, "*"):
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("route %q: catch-all %q must be the last segment", template, part)
+			}
+			name := part[1:]
+			if name == "" {
+				return nil, fmt.Errorf("route %q: catch-all needs a name", template)
+			}
+			if _, dup := names[name]; dup {
+				return nil, fmt.Errorf("route %q: duplicate placeholder %q", template, name)
+			}
+			names[name] = struct{}{}
+			segments = append(segments, routeSegment{kind: segmentCatchAll, name: name})
+
+		case strings.HasPrefix(part, "{"):
+			if !strings.HasSuffix(part, "}") {
+				return nil, fmt.Errorf("route %q: unterminated placeholder %q", template, part)
+			}
+			name, typ, _ := strings.Cut(part[1:len(part)-1], ":")
+			if name == "" {
+				return nil, fmt.Errorf("route %q: placeholder %q needs a name", template, part)
+			}
+			check, ok := routeParamTypes[typ]
+			if !ok {
+				return nil, fmt.Errorf("route %q: unknown placeholder type %q", template, typ)
+			}
+			if _, dup := names[name]; dup {
+				return nil, fmt.Errorf("route %q: duplicate placeholder %q", template, name)
+			}
+			names[name] = struct{}{}
+			segments = append(segments, routeSegment{kind: segmentParam, name: name, typ: typ, check: check})
+
+		default:
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("route %q: placeholder must occupy a whole segment in %q", template, part)
+			}
+			segments = append(segments, routeSegment{kind: segmentLiteral, literal: part})
+		}
+	}
+
+	return &routeTemplate{
+		template: template,
+		segments: segments,
+	}, nil
+}
+
+// match reports whether the path satisfies the template
+func (rt *routeTemplate) match(path string) bool {
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	rest := path[1:]
+
+	for i, seg := range rt.segments {
+		if seg.kind == segmentCatchAll {
+			return rest != ""
+		}
+
+		part, tail, more := strings.Cut(rest, "/")
+		switch seg.kind {
+		case segmentLiteral:
+			if part != seg.literal {
+				return false
+			}
+		case segmentParam:
+			if !seg.check(part) {
+				return false
+			}
+		}
+
+		if i == len(rt.segments)-1 {
+			return !more
+		}
+		if !more {
+			return false
+		}
+		rest = tail
+	}
+
+	return false
+}
+
+// captures returns the path followed by the placeholder values in template
+// order. Must only be called for paths the template matches
+func (rt *routeTemplate) captures(path string) []string {
+	captures := make([]string, 1, len(rt.segments)+1)
+	captures[0] = path
+
+	rest := strings.TrimPrefix(path, "/")
+	for _, seg := range rt.segments {
+		if seg.kind == segmentCatchAll {
+			return append(captures, rest)
+		}
+
+		part, tail, _ := strings.Cut(rest, "/")
+		if seg.kind == segmentParam {
+			captures = append(captures, part)
+		}
+		rest = tail
+	}
+
+	return captures
+}
+
+// paramIndex returns the capture index of a placeholder or -1
+func (rt *routeTemplate) paramIndex(name string) int {
+	index := 0
+	for _, seg := range rt.segments {
+		if seg.kind == segmentLiteral {
+			continue
+		}
+		index++
+		if seg.name == name {
+			return index
+		}
+	}
+	return -1
+}
+
+// params returns the number of placeholders
+func (rt *routeTemplate) params() int {
+	n := 0
+	for _, seg := range rt.segments {
+		if seg.kind != segmentLiteral {
+			n++
+		}
+	}
+	return n
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexByte(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isHexByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHexByte(s[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isAlphaByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlpha(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isAlphaByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isAlphaByte(s[i]) && (s[i] < '0' || s[i] > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// isSlug accepts lowercase words separated by single dashes, e.g. "my-post-1"
+func isSlug(s string) bool {
+	if s == "" || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-':
+			if s[i-1] == '-' {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
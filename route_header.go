@@ -0,0 +1,62 @@
+package prometheus
+
+import (
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+)
+
+// routeHeader validates endpoint names reported by the worker through a
+// response header, e.g. X-RR-Route: app_user_show
+type routeHeader struct {
+	name      string
+	allow     []*regexp.Regexp
+	maxLength int
+}
+
+// newRouteHeader builds the route header validator. Returns nil if disabled
+func newRouteHeader(config RouteHeaderConfig) (*routeHeader, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	allow, err := compilePatterns(config.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	name := config.Name
+	if name == "" {
+		name = "X-RR-Route"
+	}
+
+	maxLength := config.MaxLength
+	if maxLength <= 0 {
+		maxLength = 128
+	}
+
+	return &routeHeader{
+		name:      http.CanonicalHeaderKey(name),
+		allow:     allow,
+		maxLength: maxLength,
+	}, nil
+}
+
+// label returns the value if it is acceptable as an endpoint label
+func (rh *routeHeader) label(value string) (string, bool) {
+	if value == "" || len(value) > rh.maxLength || !utf8.ValidString(value) {
+		return "", false
+	}
+
+	if len(rh.allow) == 0 {
+		return value, true
+	}
+
+	for _, regex := range rh.allow {
+		if regex.MatchString(value) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
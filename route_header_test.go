@@ -0,0 +1,75 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouteHeaderLabel(t *testing.T) {
+	rh, err := newRouteHeader(RouteHeaderConfig{
+		Enabled:   true,
+		Allow:     []string{`^app_[a-z_]+$`},
+		MaxLength: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rh.name != "X-Rr-Route" {
+		t.Errorf("name = %q, want the canonical default header", rh.name)
+	}
+
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"app_user_show", true},
+		{"", false},
+		{"user_show", false},
+		{"app_" + strings.Repeat("x", 13), false},
+		{"app_\xff", false},
+	}
+	for _, tt := range tests {
+		if _, ok := rh.label(tt.value); ok != tt.ok {
+			t.Errorf("label(%q) accepted = %v, want %v", tt.value, ok, tt.ok)
+		}
+	}
+}
+
+func TestRouteHeaderDisabled(t *testing.T) {
+	rh, err := newRouteHeader(RouteHeaderConfig{Enabled: false})
+	if err != nil || rh != nil {
+		t.Errorf("disabled route header = %v, %v; want nil, nil", rh, err)
+	}
+}
+
+func TestMiddlewareRouteHeader(t *testing.T) {
+	p := newTestPlugin(t, func(c *Config) {
+		c.RouteHeader.Enabled = true
+		c.RouteHeader.Allow = []string{`^app_`}
+		c.EndpointPatterns.Rules = []PatternRule{{Pattern: "^/users/", Name: "users"}}
+	})
+
+	handler := func(route string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-RR-Route", route)
+			_, _ = w.Write([]byte("ok"))
+		}
+	}
+
+	for _, route := range []string{"app_user_show", "rejected"} {
+		rec := serveRequest(p, handler(route), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+		if v := rec.Header().Get("X-RR-Route"); v != "" {
+			t.Errorf("route header %q reached the client", v)
+		}
+	}
+
+	families := gather(t, p)
+	for endpoint, want := range map[string]float64{"app_user_show": 1, "users": 1} {
+		labels := map[string]string{"endpoint": endpoint, "status": "200"}
+		if v := metricValue(families, "rr_http_requests_by_endpoint_total", labels); v != want {
+			t.Errorf("requests_by_endpoint_total{endpoint=%q} = %v, want %v", endpoint, v, want)
+		}
+	}
+}
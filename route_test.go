@@ -0,0 +1,162 @@
+package prometheus
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRouteErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"relative", "api/users"},
+		{"catch-all not last", "/files/*path/raw"},
+		{"catch-all without name", "/files/*"},
+		{"unterminated placeholder", "/users/{id"},
+		{"placeholder without name", "/users/{:int}"},
+		{"unknown type", "/users/{id:float}"},
+		{"duplicate placeholder", "/users/{id}/posts/{id}"},
+		{"duplicate catch-all", "/users/{path}/*path"},
+		{"partial segment", "/users/id-{id}"},
+		{"stray brace", "/users/id}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseRoute(tt.template); err == nil {
+				t.Fatalf("parseRoute(%q) succeeded, want an error", tt.template)
+			}
+		})
+	}
+}
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		want     bool
+	}{
+		{"/", "/", true},
+		{"/", "/users", false},
+		{"/api/users", "/api/users", true},
+		{"/api/users", "/api/users/", false},
+		{"/api/users", "/api/users/1", false},
+		{"/api/users", "/api", false},
+		{"/api/users", "api/users", false},
+
+		{"/users/{id}", "/users/abc", true},
+		{"/users/{id}", "/users/", false},
+		{"/users/{id}", "/users/1/posts", false},
+
+		{"/users/{id:int}", "/users/42", true},
+		{"/users/{id:int}", "/users/4a", false},
+		{"/users/{id:int}", "/users/-1", false},
+
+		{"/orders/{id:uuid}", "/orders/123e4567-e89b-12d3-a456-426614174000", true},
+		{"/orders/{id:uuid}", "/orders/123e4567e89b12d3a456426614174000", false},
+		{"/orders/{id:uuid}", "/orders/123e4567-e89b-12d3-a456-42661417400g", false},
+
+		{"/blobs/{sha:hex}", "/blobs/deadBEEF01", true},
+		{"/blobs/{sha:hex}", "/blobs/xyz", false},
+
+		{"/tags/{tag:alpha}", "/tags/Go", true},
+		{"/tags/{tag:alpha}", "/tags/go1", false},
+		{"/codes/{code:alnum}", "/codes/ab12", true},
+		{"/codes/{code:alnum}", "/codes/ab-12", false},
+
+		{"/posts/{slug:slug}", "/posts/my-first-post", true},
+		{"/posts/{slug:slug}", "/posts/My-Post", false},
+		{"/posts/{slug:slug}", "/posts/-post", false},
+		{"/posts/{slug:slug}", "/posts/post-", false},
+		{"/posts/{slug:slug}", "/posts/my--post", false},
+
+		{"/files/*path", "/files/a", true},
+		{"/files/*path", "/files/a/b/c.txt", true},
+		{"/files/*path", "/files/", false},
+		{"/files/*path", "/files", false},
+		{"/users/{id:int}/files/*path", "/users/1/files/a/b", true},
+		{"/users/{id:int}/files/*path", "/users/x/files/a/b", false},
+	}
+
+	for _, tt := range tests {
+		rt, err := parseRoute(tt.template)
+		if err != nil {
+			t.Fatalf("parseRoute(%q): %v", tt.template, err)
+		}
+		if got := rt.match(tt.path); got != tt.want {
+			t.Errorf("%q.match(%q) = %v, want %v", tt.template, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteCaptures(t *testing.T) {
+	rt, err := parseRoute("/users/{id:int}/posts/{slug}/*rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/users/7/posts/hello/a/b"
+	if !rt.match(path) {
+		t.Fatalf("template does not match %q", path)
+	}
+
+	want := []string{path, "7", "hello", "a/b"}
+	if got := rt.captures(path); !slices.Equal(got, want) {
+		t.Errorf("captures = %q, want %q", got, want)
+	}
+
+	if rt.params() != 3 {
+		t.Errorf("params = %d, want 3", rt.params())
+	}
+	for name, want := range map[string]int{"id": 1, "slug": 2, "rest": 3, "missing": -1} {
+		if got := rt.paramIndex(name); got != want {
+			t.Errorf("paramIndex(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestRouteRules(t *testing.T) {
+	em, err := NewEndpointMatcher(EndpointPatternsConfig{
+		Enabled:   true,
+		CacheSize: 100,
+		Rules: []PatternRule{
+			{Route: "/api/users/{id:int}"},
+			{Route: "/api/users/{id:int}/posts/{post:slug}", Name: "user_post"},
+			{Route: "/static/*file", Name: "static"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/users/42", "/api/users/{id:int}"},
+		{"/api/users/42/posts/hello-world", "user_post"},
+		{"/api/users/abc", "other"},
+		{"/static/css/site.css", "static"},
+		{"/static/", "other"},
+	}
+	for _, tt := range tests {
+		if got := em.Match(RequestInfo{Method: "GET", Path: tt.path}); got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteRulesInvalid(t *testing.T) {
+	tests := []PatternRule{
+		{Route: "/users/{id:float}"},
+		{Route: "/users/{id}", Pattern: "^/users/"},
+		{Name: "empty"},
+	}
+	for _, rule := range tests {
+		_, err := NewEndpointMatcher(EndpointPatternsConfig{Enabled: true, Rules: []PatternRule{rule}})
+		if err == nil {
+			t.Errorf("rule %+v accepted, want an error", rule)
+		}
+	}
+}
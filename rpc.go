@@ -0,0 +1,23 @@
+package prometheus
+
+// rpc exposes plugin management methods over RoadRunner RPC
+type rpc struct {
+	p *Plugin
+}
+
+// RPC returns the RPC service of the plugin
+func (p *Plugin) RPC() any {
+	return &rpc{p: p}
+}
+
+// ReloadRules re-reads the endpoint rules file and atomically swaps the rules.
+// On failure the previous rules remain active. Returns the number of active rules
+func (r *rpc) ReloadRules(_ bool, out *int) error {
+	n, err := r.p.reloadRules()
+	if err != nil {
+		return err
+	}
+
+	*out = n
+	return nil
+}
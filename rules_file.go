@@ -0,0 +1,86 @@
+package prometheus
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// rulesFile is the layout of the external endpoint rules file (YAML or JSON):
+//
+//	rules:
+//	  - route: "/api/users/{id:int}"
+//	  - pattern: "^/admin/.*"
+//	    name: "/admin/:path"
+type rulesFile struct {
+	Rules []PatternRule `mapstructure:"rules"`
+}
+
+// rulesFileState identifies a version of the rules file on disk
+type rulesFileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// statRulesFile returns the version of the rules file on disk
+func statRulesFile(path string) (rulesFileState, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return rulesFileState{}, err
+	}
+	return rulesFileState{modTime: stat.ModTime(), size: stat.Size()}, nil
+}
+
+// loadRulesFile reads endpoint rules from a YAML or JSON file.
+// Rules use the same keys as http_metrics.endpoint_patterns.rules.
+// The version is stated before parsing and returned even when the file is invalid
+func loadRulesFile(path string) ([]PatternRule, rulesFileState, error) {
+	state, err := statRulesFile(path)
+	if err != nil {
+		return nil, rulesFileState{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, state, err
+	}
+
+	var raw map[string]any
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return nil, state, fmt.Errorf("rules file %s: %w", path, err)
+	}
+
+	var rf rulesFile
+	if err = mapstructure.Decode(raw, &rf); err != nil {
+		return nil, state, fmt.Errorf("rules file %s: %w", path, err)
+	}
+
+	return rf.Rules, state, nil
+}
+
+// LoadRules returns the inline rules followed by the rules from RulesFile, if set
+func (c EndpointPatternsConfig) LoadRules() ([]PatternRule, error) {
+	if c.RulesFile == "" {
+		return c.Rules, nil
+	}
+
+	fileRules, _, err := loadRulesFile(c.RulesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(slices.Clone(c.Rules), fileRules...), nil
+}
+
+// rulesFileChanged reports whether the file on disk differs from the last loaded version
+func rulesFileChanged(path string, last rulesFileState) bool {
+	state, err := statRulesFile(path)
+	if err != nil {
+		return false
+	}
+	return !state.modTime.Equal(last.modTime) || state.size != last.size
+}
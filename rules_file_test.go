@@ -0,0 +1,178 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeRulesFile replaces the rules file and moves its modification time
+// forward, so that the change is noticed regardless of the file system resolution
+func writeRulesFile(t *testing.T, path, content string, version int) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000+int64(version), 0)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newRulesFilePlugin(t *testing.T, content string, configure func(*Config)) (*Plugin, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRulesFile(t, path, content, 0)
+
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.EndpointPatterns.Enabled = true
+		cfg.EndpointPatterns.RulesFile = path
+		if configure != nil {
+			configure(cfg)
+		}
+	})
+	return p, path
+}
+
+func requestLabel(p *Plugin, path string) string {
+	return p.endpoint(newRequestInfo(httptest.NewRequest(http.MethodGet, path, nil)), "", nil).label
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "rules.yaml")
+	writeRulesFile(t, yamlPath, "rules:\n  - route: /users/{id:int}\n  - pattern: ^/admin/.*\n    name: admin\n", 0)
+	rules, state, err := loadRulesFile(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Route != "/users/{id:int}" || rules[1].Name != "admin" {
+		t.Errorf("rules = %+v", rules)
+	}
+	if state.modTime.IsZero() || state.size == 0 {
+		t.Errorf("state = %+v, want the file version", state)
+	}
+
+	jsonPath := filepath.Join(dir, "rules.json")
+	writeRulesFile(t, jsonPath, `{"rules": [{"route": "/orders/{id:uuid}", "name": "order"}]}`, 0)
+	if rules, _, err = loadRulesFile(jsonPath); err != nil || len(rules) != 1 || rules[0].Name != "order" {
+		t.Errorf("json rules = %+v, %v", rules, err)
+	}
+
+	brokenPath := filepath.Join(dir, "broken.yaml")
+	writeRulesFile(t, brokenPath, "rules: [", 0)
+	if _, state, err = loadRulesFile(brokenPath); err == nil || state.modTime.IsZero() {
+		t.Errorf("broken file: state %+v, err %v; want the version and an error", state, err)
+	}
+
+	if _, _, err = loadRulesFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("missing file loaded")
+	}
+}
+
+func TestReloadRules(t *testing.T) {
+	p, path := newRulesFilePlugin(t, "rules:\n  - route: /users/{id:int}\n    name: user\n", func(cfg *Config) {
+		cfg.EndpointPatterns.Rules = []PatternRule{{Route: "/health", Name: "health"}}
+	})
+
+	if got := requestLabel(p, "/users/1"); got != "user" {
+		t.Fatalf("label before reload = %q, want user", got)
+	}
+
+	writeRulesFile(t, path, "rules:\n  - route: /users/{id:int}\n    name: account\n", 1)
+	p.checkRulesFile()
+
+	// the cache is purged, inline rules are kept
+	if got := requestLabel(p, "/users/1"); got != "account" {
+		t.Errorf("label after reload = %q, want account", got)
+	}
+	if got := requestLabel(p, "/health"); got != "health" {
+		t.Errorf("inline rule label = %q, want health", got)
+	}
+
+	// an unchanged file is not reloaded
+	p.checkRulesFile()
+
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_endpoint_rules_reload_total", map[string]string{"result": "success"}); v != 1 {
+		t.Errorf("successful reloads = %v, want 1", v)
+	}
+}
+
+func TestReloadRulesBrokenFile(t *testing.T) {
+	p, path := newRulesFilePlugin(t, "rules:\n  - route: /users/{id:int}\n    name: user\n", nil)
+
+	writeRulesFile(t, path, "rules: [", 1)
+	for i := 0; i < 3; i++ {
+		p.checkRulesFile()
+	}
+
+	// the broken version counts once and the previous rules stay active
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_endpoint_rules_reload_total", map[string]string{"result": "failure"}); v != 1 {
+		t.Errorf("failed reloads = %v, want 1", v)
+	}
+	if got := requestLabel(p, "/users/1"); got != "user" {
+		t.Errorf("label = %q, want the previous rules", got)
+	}
+
+	// invalid rules in a valid file are rejected the same way
+	writeRulesFile(t, path, "rules:\n  - route: /users/{id:float}\n", 2)
+	p.checkRulesFile()
+	p.checkRulesFile()
+
+	writeRulesFile(t, path, "rules:\n  - route: /users/{id:int}\n    name: account\n", 3)
+	p.checkRulesFile()
+
+	families = gather(t, p)
+	if v := metricValue(families, "rr_http_endpoint_rules_reload_total", map[string]string{"result": "failure"}); v != 2 {
+		t.Errorf("failed reloads = %v, want 2", v)
+	}
+	if v := metricValue(families, "rr_http_endpoint_rules_reload_total", map[string]string{"result": "success"}); v != 1 {
+		t.Errorf("successful reloads = %v, want 1", v)
+	}
+	if got := requestLabel(p, "/users/1"); got != "account" {
+		t.Errorf("label after the fix = %q, want account", got)
+	}
+}
+
+func TestReloadRulesKeepsPatternSlots(t *testing.T) {
+	p, path := newRulesFilePlugin(t, "rules:\n  - route: /users/{id:int}\n    name: user\n", func(cfg *Config) {
+		cfg.EndpointPatterns.MaxPatterns = 1
+	})
+
+	if got := requestLabel(p, "/users/1"); got != "user" {
+		t.Fatalf("label = %q, want user", got)
+	}
+
+	writeRulesFile(t, path, "rules:\n  - route: /users/{id:int}\n    name: account\n", 1)
+	if _, err := p.reloadRules(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the series of the retired name are still exported, it keeps the only slot
+	if got := requestLabel(p, "/users/2"); got != "__overflow__" {
+		t.Errorf("label after reload = %q, want __overflow__", got)
+	}
+
+	writeRulesFile(t, path, "rules:\n  - route: /users/{id:int}\n    name: user\n", 2)
+	if _, err := p.reloadRules(); err != nil {
+		t.Fatal(err)
+	}
+	if got := requestLabel(p, "/users/3"); got != "user" {
+		t.Errorf("label after restoring the rule = %q, want user", got)
+	}
+}
+
+func TestReloadRulesDisabled(t *testing.T) {
+	p := newTestPlugin(t, nil)
+	if _, err := p.reloadRules(); err == nil {
+		t.Error("reload without a rules file succeeded")
+	}
+}
@@ -0,0 +1,201 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		code      int
+		noWorkers bool
+		want      ErrorType
+	}{
+		{http.StatusNotFound, false, ErrorTypeClientError},
+		{http.StatusRequestTimeout, false, ErrorTypeTimeout},
+		{http.StatusGatewayTimeout, false, ErrorTypeTimeout},
+		{statusClientClosed, false, ErrorTypeClientClosed},
+		{http.StatusBadGateway, false, ErrorTypeServerError},
+		{http.StatusInternalServerError, true, ErrorTypeNoWorkers},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.noWorkers {
+			h.Set(noWorkers, trueStr)
+		}
+		if got := classifyError(tt.code, h); got != tt.want {
+			t.Errorf("classifyError(%d, no workers %v) = %q, want %q", tt.code, tt.noWorkers, got, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter, cancel context.CancelFunc)
+		want    string
+	}{
+		{"implicit 200 on write", func(w http.ResponseWriter, _ context.CancelFunc) {
+			_, _ = w.Write([]byte("ok"))
+		}, "200"},
+		{"implicit 200 on flush", func(w http.ResponseWriter, _ context.CancelFunc) {
+			w.(http.Flusher).Flush()
+		}, "200"},
+		{"nothing written", func(http.ResponseWriter, context.CancelFunc) {}, "200"},
+		{"first status wins", func(w http.ResponseWriter, _ context.CancelFunc) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "404"},
+		{"status after write ignored", func(w http.ResponseWriter, _ context.CancelFunc) {
+			_, _ = w.Write([]byte("ok"))
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "200"},
+		{"informational before the status", func(w http.ResponseWriter, _ context.CancelFunc) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusAccepted)
+		}, "202"},
+		{"client gone before the response", func(w http.ResponseWriter, cancel context.CancelFunc) {
+			w.Header().Set("Content-Type", "text/plain")
+			cancel()
+		}, "499"},
+		{"client gone after the response", func(w http.ResponseWriter, cancel context.CancelFunc) {
+			w.WriteHeader(http.StatusCreated)
+			cancel()
+		}, "201"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+			serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+				tt.handler(w, cancel)
+			}, r)
+
+			mf := gather(t, p)["rr_http_request_total"]
+			if mf == nil || len(mf.GetMetric()) != 1 {
+				t.Fatalf("request_total = %v, want a single series", mf)
+			}
+			if got := mf.GetMetric()[0].GetLabel()[0].GetValue(); got != tt.want {
+				t.Errorf("status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareDeadline(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	serveRequest(p, func(http.ResponseWriter, *http.Request) {}, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	if v := metricValue(gather(t, p), "rr_http_request_total", map[string]string{"status": "200"}); v != 1 {
+		t.Errorf("request_total{status=200} = %v, want 1 for a request past its deadline", v)
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		status  string
+		panics  float64
+		errType string
+	}{
+		{"panic", "boom", "500", 1, string(ErrorTypePanic)},
+		// net/http aborts the response silently, it is not a handler failure
+		{"abort handler", http.ErrAbortHandler, "200", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, nil)
+
+			func() {
+				defer func() {
+					if recovered := recover(); recovered != tt.value {
+						t.Errorf("recovered %v, want the handler panic re-raised", recovered)
+					}
+				}()
+				serveRequest(p, func(http.ResponseWriter, *http.Request) {
+					panic(tt.value)
+				}, httptest.NewRequest(http.MethodGet, "/", nil))
+			}()
+
+			families := gather(t, p)
+			if v := metricValue(families, "rr_http_request_total", map[string]string{"status": tt.status}); v != 1 {
+				t.Errorf("request_total{status=%s} = %v, want 1", tt.status, v)
+			}
+			if v := metricValue(families, "rr_http_panics_total", nil); v != tt.panics {
+				t.Errorf("panics_total = %v, want %v", v, tt.panics)
+			}
+			if tt.errType != "" {
+				labels := map[string]string{"type": tt.errType, "status": tt.status}
+				if v := metricValue(families, "rr_http_errors_total", labels); v != 1 {
+					t.Errorf("errors_total{type=%s} = %v, want 1", tt.errType, v)
+				}
+			}
+			// the request left the queue despite the panic
+			if v := metricValue(families, "rr_http_requests_queue", nil); v != 0 {
+				t.Errorf("requests_queue = %v, want 0", v)
+			}
+		})
+	}
+}
+
+func TestMiddlewarePanicAfterStatus(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	func() {
+		defer func() { _ = recover() }()
+		serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			panic("boom")
+		}, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_request_total", map[string]string{"status": "500"}); v != 1 {
+		t.Errorf("request_total{status=500} = %v, want 1", v)
+	}
+	if v := metricValue(families, "rr_http_request_total", map[string]string{"status": "200"}); v != -1 {
+		t.Errorf("request_total{status=200} = %v, want no series", v)
+	}
+}
+
+func panickingHandler(http.ResponseWriter, *http.Request) {
+	panic("boom")
+}
+
+func TestMiddlewarePanicStack(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	var stack []byte
+	func() {
+		// net/http logs the stack of the re-raised panic the same way
+		defer func() {
+			_ = recover()
+			stack = debug.Stack()
+		}()
+		serveRequest(p, panickingHandler, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	if !strings.Contains(string(stack), "panickingHandler") {
+		t.Errorf("stack of the re-raised panic lost the handler frames:\n%s", stack)
+	}
+	if v := metricValue(gather(t, p), "rr_http_panics_total", nil); v != 1 {
+		t.Errorf("panics_total = %v, want 1", v)
+	}
+}
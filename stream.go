@@ -0,0 +1,171 @@
+package prometheus
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// streamTracker records streamed responses such as Server-Sent Events. A
+// response is a stream when its content type is one of contentTypes or
+// when it is flushed more than once
+type streamTracker struct {
+	contentTypes []string
+
+	// resolve returns the endpoint of a response, it is only called once per stream
+	resolve func(w *writer) endpointResult
+
+	flushes    *prometheus.HistogramVec
+	flushBytes *prometheus.HistogramVec
+	flushGap   *prometheus.HistogramVec
+	lifetime   *prometheus.HistogramVec
+}
+
+func newStreamTracker(cfg StreamingConfig, gapBuckets []float64, resolve func(*writer) endpointResult) *streamTracker {
+	contentTypes := make([]string, 0, len(cfg.ContentTypes))
+	for _, ct := range cfg.ContentTypes {
+		contentTypes = append(contentTypes, strings.ToLower(strings.TrimSpace(ct)))
+	}
+
+	labels := []string{"method", "endpoint"}
+	return &streamTracker{
+		contentTypes: contentTypes,
+		resolve:      resolve,
+		flushes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "stream_flushes",
+				Help:      "Number of flushes per streamed response.",
+				Buckets:   prometheus.ExponentialBuckets(1, 4, 8), // 1 to 16384
+			},
+			labels,
+		),
+		flushBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "stream_flush_bytes",
+				Help:      "Bytes written between two flushes of a streamed response.",
+				Buckets:   prometheus.ExponentialBuckets(64, 4, 8), // 64B to 1MB
+			},
+			labels,
+		),
+		flushGap: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "stream_flush_gap_seconds",
+				Help:      "Time between two consecutive flushes of a streamed response.",
+				Buckets:   gapBuckets,
+			},
+			labels,
+		),
+		lifetime: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "stream_duration_seconds",
+				Help:      "Lifetime of streamed responses, from the request arrival until the handler returned.",
+				Buckets:   cfg.LifetimeBuckets,
+			},
+			labels,
+		),
+	}
+}
+
+func (t *streamTracker) collectors() []prometheus.Collector {
+	return []prometheus.Collector{t.flushes, t.flushBytes, t.flushGap, t.lifetime}
+}
+
+// streamContentType reports whether the content type marks a stream
+func (t *streamTracker) streamContentType(contentType string) bool {
+	if contentType == "" || len(t.contentTypes) == 0 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(t.contentTypes, mediaType)
+}
+
+// streamState follows the flushes of a single response
+type streamState struct {
+	tracker *streamTracker
+	req     *http.Request
+
+	flushes      int
+	lastFlush    time.Time
+	flushedBytes int64 // response bytes written up to the last flush
+
+	detected bool
+	result   endpointResult
+	labels   prometheus.Labels
+}
+
+// flushed is called after every flush with the response bytes written so far
+func (s *streamState) flushed(w *writer) {
+	if s.tracker == nil {
+		return
+	}
+
+	now := time.Now()
+	s.flushes++
+
+	if !s.detected && (s.flushes > 1 || s.tracker.streamContentType(w.w.Header().Get("Content-Type"))) {
+		s.detect(w)
+		// the first flush was not known to be part of a stream yet
+		if s.flushes > 1 {
+			s.tracker.flushBytes.With(s.labels).Observe(float64(s.flushedBytes))
+		}
+	}
+
+	if s.detected {
+		s.tracker.flushBytes.With(s.labels).Observe(float64(w.bytesWritten - s.flushedBytes))
+		if !s.lastFlush.IsZero() {
+			s.tracker.flushGap.With(s.labels).Observe(now.Sub(s.lastFlush).Seconds())
+		}
+	}
+
+	s.lastFlush = now
+	s.flushedBytes = w.bytesWritten
+}
+
+// finish checks a response that was never flushed twice for a stream
+// content type once the handler returned
+func (s *streamState) finish(w *writer) {
+	if s.tracker == nil || s.detected {
+		return
+	}
+	if s.tracker.streamContentType(w.w.Header().Get("Content-Type")) {
+		s.detect(w)
+	}
+}
+
+// detect marks the response as a stream and resolves its endpoint
+func (s *streamState) detect(w *writer) {
+	s.detected = true
+	s.result = s.tracker.resolve(w)
+	s.labels = prometheus.Labels{
+		"method":   s.req.Method,
+		"endpoint": s.result.label,
+	}
+}
+
+// observe records the flush count and lifetime of a detected stream
+func (s *streamState) observe(lifetime time.Duration) {
+	s.tracker.flushes.With(s.labels).Observe(float64(s.flushes))
+	s.tracker.lifetime.With(s.labels).Observe(lifetime.Seconds())
+}
+
+func (s *streamState) reset() {
+	s.tracker = nil
+	s.req = nil
+	s.flushes = 0
+	s.lastFlush = time.Time{}
+	s.flushedBytes = 0
+	s.detected = false
+	s.result = endpointResult{}
+	s.labels = nil
+}
@@ -0,0 +1,205 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// flushChunks returns a handler writing and flushing each chunk with the content type
+func flushChunks(contentType string, chunks ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		for _, chunk := range chunks {
+			_, _ = w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// histogramOf returns the sample count and sum of a histogram series, a
+// count of -1 if it is missing
+func histogramOf(families map[string]*dto.MetricFamily, name string, labels map[string]string) (int, float64) {
+	m := findMetric(families, name, labels)
+	if m == nil || m.GetHistogram() == nil {
+		return -1, 0
+	}
+	return int(m.GetHistogram().GetSampleCount()), m.GetHistogram().GetSampleSum()
+}
+
+func TestStreamMetrics(t *testing.T) {
+	type sample struct {
+		count int
+		sum   float64
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		// samples of stream_flushes and stream_flush_bytes, a count of -1 is no series
+		flushes    sample
+		flushBytes sample
+		flushGaps  int
+		lifetime   int
+	}{
+		{
+			name:       "event stream",
+			handler:    flushChunks("text/event-stream", "data: 1\n\n", "data: 2\n\n", "data: 3\n\n"),
+			flushes:    sample{1, 3},
+			flushBytes: sample{3, 27},
+			flushGaps:  2,
+			lifetime:   1,
+		},
+		{
+			name:       "event stream with parameters",
+			handler:    flushChunks("Text/Event-Stream; charset=utf-8", "data: 1\n\n"),
+			flushes:    sample{1, 1},
+			flushBytes: sample{1, 9},
+			flushGaps:  -1,
+			lifetime:   1,
+		},
+		{
+			// the first flush is only known to be part of a stream on the second one
+			name:       "repeated flushes",
+			handler:    flushChunks("application/json", `{"a":1}`, `{"b":22}`),
+			flushes:    sample{1, 2},
+			flushBytes: sample{2, 15},
+			flushGaps:  1,
+			lifetime:   1,
+		},
+		{
+			name:       "event stream never flushed",
+			handler:    flushChunks("text/event-stream"),
+			flushes:    sample{1, 0},
+			flushBytes: sample{-1, 0},
+			flushGaps:  -1,
+			lifetime:   1,
+		},
+		{
+			name:       "single flush",
+			handler:    flushChunks("text/html", "<html>"),
+			flushes:    sample{-1, 0},
+			flushBytes: sample{-1, 0},
+			flushGaps:  -1,
+			lifetime:   -1,
+		},
+		{
+			name:       "not flushed",
+			handler:    flushChunks("text/html"),
+			flushes:    sample{-1, 0},
+			flushBytes: sample{-1, 0},
+			flushGaps:  -1,
+			lifetime:   -1,
+		},
+	}
+
+	labels := map[string]string{"method": "GET", "endpoint": "other"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, nil)
+			serveRequest(p, tt.handler, httptest.NewRequest(http.MethodGet, "/events", nil))
+			families := gather(t, p)
+
+			if count, sum := histogramOf(families, "rr_http_stream_flushes", labels); count != tt.flushes.count || sum != tt.flushes.sum {
+				t.Errorf("stream_flushes = %d samples, sum %v; want %+v", count, sum, tt.flushes)
+			}
+			if count, sum := histogramOf(families, "rr_http_stream_flush_bytes", labels); count != tt.flushBytes.count || sum != tt.flushBytes.sum {
+				t.Errorf("stream_flush_bytes = %d samples, sum %v; want %+v", count, sum, tt.flushBytes)
+			}
+			if count, _ := histogramOf(families, "rr_http_stream_flush_gap_seconds", labels); count != tt.flushGaps {
+				t.Errorf("stream_flush_gap_seconds = %d samples, want %d", count, tt.flushGaps)
+			}
+			if count, _ := histogramOf(families, "rr_http_stream_duration_seconds", labels); count != tt.lifetime {
+				t.Errorf("stream_duration_seconds = %d samples, want %d", count, tt.lifetime)
+			}
+		})
+	}
+}
+
+func TestStreamRouteHeader(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.RouteHeader.Enabled = true
+		cfg.RouteHeader.Allow = []string{`^app_`}
+	})
+
+	rec := serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Route", "app_events")
+		flushChunks("text/event-stream", "data: 1\n\n", "data: 2\n\n")(w, nil)
+	}, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if v := rec.Header().Get("X-RR-Route"); v != "" {
+		t.Errorf("stream leaked the route header %q", v)
+	}
+	families := gather(t, p)
+	labels := map[string]string{"method": "GET", "endpoint": "app_events"}
+	if count, _ := histogramOf(families, "rr_http_stream_flushes", labels); count != 1 {
+		t.Errorf("stream_flushes{endpoint=app_events} = %d samples, want 1", count)
+	}
+	if v := metricValue(families, "rr_http_requests_by_endpoint_total", labels); v != 1 {
+		t.Errorf("requests_by_endpoint_total{endpoint=app_events} = %v, want 1", v)
+	}
+}
+
+func TestStreamExcludeFromDuration(t *testing.T) {
+	durations := []string{
+		"rr_http_request_duration_seconds",
+		"rr_http_duration_by_endpoint_seconds",
+		"rr_http_processing_time_seconds",
+	}
+
+	for _, exclude := range []bool{false, true} {
+		p := newTestPlugin(t, func(cfg *Config) {
+			cfg.Streaming.ExcludeFromDuration = exclude
+		})
+
+		serveRequest(p, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-RR-Worker-Start", strconv.FormatInt(time.Now().UnixMicro(), 10))
+			flushChunks("text/event-stream", "data: 1\n\n")(w, r)
+		}, httptest.NewRequest(http.MethodGet, "/events", nil))
+		families := gather(t, p)
+
+		for _, name := range durations {
+			if _, ok := families[name]; ok == exclude {
+				t.Errorf("exclude_from_duration %v: %s recorded = %v", exclude, name, ok)
+			}
+		}
+		if v := metricValue(families, "rr_http_request_total", map[string]string{"status": "200"}); v != 1 {
+			t.Errorf("exclude_from_duration %v: request_total = %v, want 1", exclude, v)
+		}
+		// the queue wait does not depend on the stream lifetime
+		if v := metricValue(families, "rr_http_queue_time_seconds", map[string]string{"endpoint": "other"}); v != 1 {
+			t.Errorf("exclude_from_duration %v: queue_time_seconds count = %v, want 1", exclude, v)
+		}
+		// regular responses keep their duration
+		if exclude {
+			serveRequest(p, flushChunks("text/html", "<html>"), httptest.NewRequest(http.MethodGet, "/page", nil))
+			if _, ok := gather(t, p)["rr_http_request_duration_seconds"]; !ok {
+				t.Error("request_duration_seconds not recorded for a regular response")
+			}
+		}
+	}
+}
+
+func TestStreamingDisabled(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.Streaming.Enabled = false
+	})
+
+	rec := serveRequest(p, flushChunks("text/event-stream", "data: 1\n\n", "data: 2\n\n"), httptest.NewRequest(http.MethodGet, "/events", nil))
+	if !rec.Flushed || rec.Body.String() != "data: 1\n\ndata: 2\n\n" {
+		t.Fatalf("flushed %v, body %q", rec.Flushed, rec.Body.String())
+	}
+
+	for name := range gather(t, p) {
+		if strings.HasPrefix(name, "rr_http_stream_") {
+			t.Errorf("%s recorded with streaming disabled", name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package prometheus
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseUnixTimestamp parses timestamps as set by proxies and workers:
+// unix seconds, milliseconds, microseconds or nanoseconds, optionally with a
+// fraction and a "t=" prefix (e.g. "t=1700000000123456" or "1700000000.123").
+// The unit is inferred from the magnitude of the value
+func parseUnixTimestamp(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(value, "t=")
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	// integers are scaled exactly, fractions go through float64
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if n <= 0 {
+			return time.Time{}, false
+		}
+		// thresholds lie far from any realistic date in the neighbouring unit
+		switch {
+		case n < 1e11: // seconds, until year 5138
+			return time.Unix(n, 0), true
+		case n < 1e14: // milliseconds
+			return time.UnixMilli(n), true
+		case n < 1e17: // microseconds
+			return time.UnixMicro(n), true
+		default: // nanoseconds
+			return time.Unix(0, n), true
+		}
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || v <= 0 || v >= math.MaxInt64 {
+		return time.Time{}, false
+	}
+
+	perSecond := 1e9
+	switch {
+	case v < 1e11:
+		perSecond = 1
+	case v < 1e14:
+		perSecond = 1e3
+	case v < 1e17:
+		perSecond = 1e6
+	}
+
+	secs := v / perSecond
+	whole := math.Floor(secs)
+	return time.Unix(int64(whole), int64((secs-whole)*1e9)), true
+}
+
+// splitAtWorkerStart splits the time from arrival to end into queue and
+// processing time at the worker start timestamp. A start slightly before the
+// arrival (clock granularity) counts as no queueing; a start after the end is rejected
+func splitAtWorkerStart(arrival, end time.Time, workerStart string) (time.Duration, time.Duration, bool) {
+	start, ok := parseUnixTimestamp(workerStart)
+	if !ok || start.After(end) {
+		return 0, 0, false
+	}
+	if start.Before(arrival) {
+		start = arrival
+	}
+	return start.Sub(arrival), end.Sub(start), true
+}
@@ -0,0 +1,118 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseUnixTimestamp(t *testing.T) {
+	want := time.Date(2023, 11, 14, 22, 13, 20, 123456789, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"1700000000", want.Truncate(time.Second)},
+		{"1700000000123", want.Truncate(time.Millisecond)},
+		{"1700000000123456", want.Truncate(time.Microsecond)},
+		{"1700000000123456789", want},
+		{"t=1700000000123456", want.Truncate(time.Microsecond)},
+		{" 1700000000 ", want.Truncate(time.Second)},
+		{"1700000000.5", want.Truncate(time.Second).Add(500 * time.Millisecond)},
+		{"1700000000123.5", want.Truncate(time.Millisecond).Add(500 * time.Microsecond)},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseUnixTimestamp(tt.value)
+		if !ok {
+			t.Errorf("parseUnixTimestamp(%q) failed", tt.value)
+			continue
+		}
+		// fractions go through float64, allow for rounding
+		if d := got.Sub(tt.want); d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("parseUnixTimestamp(%q) = %v, want %v", tt.value, got.UTC(), tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "t=", "abc", "0", "-1", "-1.5", "NaN", "1e30", "12:00"} {
+		if got, ok := parseUnixTimestamp(value); ok {
+			t.Errorf("parseUnixTimestamp(%q) = %v, want an error", value, got)
+		}
+	}
+}
+
+func TestSplitAtWorkerStart(t *testing.T) {
+	arrival := time.Unix(1700000000, 0)
+	end := arrival.Add(300 * time.Millisecond)
+	micros := func(t time.Time) string { return strconv.FormatInt(t.UnixMicro(), 10) }
+
+	queue, processing, ok := splitAtWorkerStart(arrival, end, micros(arrival.Add(100*time.Millisecond)))
+	if !ok || queue != 100*time.Millisecond || processing != 200*time.Millisecond {
+		t.Errorf("split = %v, %v, %v; want 100ms, 200ms", queue, processing, ok)
+	}
+
+	// a start before the arrival is clock granularity, not negative queueing
+	queue, processing, ok = splitAtWorkerStart(arrival, end, micros(arrival.Add(-time.Millisecond)))
+	if !ok || queue != 0 || processing != 300*time.Millisecond {
+		t.Errorf("early start = %v, %v, %v; want 0, 300ms", queue, processing, ok)
+	}
+
+	if _, _, ok = splitAtWorkerStart(arrival, end, micros(end.Add(time.Millisecond))); ok {
+		t.Error("start after the end accepted")
+	}
+	if _, _, ok = splitAtWorkerStart(arrival, end, ""); ok {
+		t.Error("missing start accepted")
+	}
+}
+
+func TestMiddlewareWorkerStart(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	rec := serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Worker-Start", strconv.FormatInt(time.Now().UnixMicro(), 10))
+	}, httptest.NewRequest(http.MethodGet, "/with", nil))
+	if v := rec.Header().Get("X-RR-Worker-Start"); v != "" {
+		t.Errorf("worker start header leaked: %q", v)
+	}
+
+	serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Worker-Start", "garbage")
+	}, httptest.NewRequest(http.MethodGet, "/invalid", nil))
+	serveRequest(p, func(http.ResponseWriter, *http.Request) {}, httptest.NewRequest(http.MethodGet, "/without", nil))
+
+	// every request has a processing time, only the one with a valid header a queue time
+	families := gather(t, p)
+	labels := map[string]string{"method": "GET", "endpoint": "other"}
+	if v := metricValue(families, "rr_http_processing_time_seconds", labels); v != 3 {
+		t.Errorf("processing_time count = %v, want 3", v)
+	}
+	if v := metricValue(families, "rr_http_queue_time_seconds", labels); v != 1 {
+		t.Errorf("queue_time count = %v, want 1", v)
+	}
+}
+
+func TestMiddlewareWorkerStartDisabled(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.WorkerStartHeader = ""
+	})
+
+	rec := serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RR-Worker-Start", strconv.FormatInt(time.Now().UnixMicro(), 10))
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// the header is neither read nor stripped, the duration is processing time
+	if rec.Header().Get("X-RR-Worker-Start") == "" {
+		t.Error("header stripped without worker_start_header")
+	}
+	families := gather(t, p)
+	labels := map[string]string{"method": "GET", "endpoint": "other"}
+	if v := metricValue(families, "rr_http_processing_time_seconds", labels); v != 1 {
+		t.Errorf("processing_time count = %v, want 1", v)
+	}
+	if v := metricValue(families, "rr_http_queue_time_seconds", labels); v != -1 {
+		t.Errorf("queue_time count = %v, want no series", v)
+	}
+}
@@ -0,0 +1,128 @@
+package prometheus
+
+import (
+	"strings"
+)
+
+// routeNode is a node of the segment trie used to match route template rules.
+// Lookup cost depends on the path depth, not on the number of rules.
+// On each level static children are tried first, then typed placeholders
+// (in insertion order), then untyped placeholders and finally a catch-all.
+//
+// Terminal nodes hold every rule registered for the template, in config
+// order; the first one accepting the request method and host wins.
+type routeNode struct {
+	static   map[string]*routeNode
+	params   []*paramEdge
+	catchAll []*compiledRule
+	leaf     []*compiledRule
+}
+
+type paramEdge struct {
+	typ   string
+	check func(string) bool
+	node  *routeNode
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{}
+}
+
+// insert adds a route rule to the trie
+func (n *routeNode) insert(rule *compiledRule) {
+	node := n
+	for _, seg := range rule.route.segments {
+		switch seg.kind {
+		case segmentLiteral:
+			if node.static == nil {
+				node.static = make(map[string]*routeNode)
+			}
+			child, ok := node.static[seg.literal]
+			if !ok {
+				child = newRouteNode()
+				node.static[seg.literal] = child
+			}
+			node = child
+
+		case segmentParam:
+			node = node.paramChild(seg)
+
+		case segmentCatchAll:
+			node.catchAll = append(node.catchAll, rule)
+			return
+		}
+	}
+
+	node.leaf = append(node.leaf, rule)
+}
+
+// paramChild returns the child for a placeholder type, creating it if needed.
+// Untyped placeholders are kept after typed ones so that the stricter check wins.
+func (n *routeNode) paramChild(seg routeSegment) *routeNode {
+	for _, edge := range n.params {
+		if edge.typ == seg.typ {
+			return edge.node
+		}
+	}
+
+	edge := &paramEdge{typ: seg.typ, check: seg.check, node: newRouteNode()}
+	if seg.typ == "" || len(n.params) == 0 || n.params[len(n.params)-1].typ != "" {
+		n.params = append(n.params, edge)
+		return edge.node
+	}
+
+	// insert before the trailing untyped edge
+	last := n.params[len(n.params)-1]
+	n.params[len(n.params)-1] = edge
+	n.params = append(n.params, last)
+	return edge.node
+}
+
+// lookup returns the rule matching the request or nil
+func (n *routeNode) lookup(req RequestInfo) *compiledRule {
+	if !strings.HasPrefix(req.Path, "/") {
+		return nil
+	}
+	return n.find(req.Path[1:], req)
+}
+
+func (n *routeNode) find(rest string, req RequestInfo) *compiledRule {
+	part, tail, more := strings.Cut(rest, "/")
+
+	if child, ok := n.static[part]; ok {
+		if rule := child.next(tail, more, req); rule != nil {
+			return rule
+		}
+	}
+
+	for _, edge := range n.params {
+		if !edge.check(part) {
+			continue
+		}
+		if rule := edge.node.next(tail, more, req); rule != nil {
+			return rule
+		}
+	}
+
+	if rest != "" {
+		return firstAccepting(n.catchAll, req)
+	}
+
+	return nil
+}
+
+func (n *routeNode) next(tail string, more bool, req RequestInfo) *compiledRule {
+	if !more {
+		return firstAccepting(n.leaf, req)
+	}
+	return n.find(tail, req)
+}
+
+func firstAccepting(rules []*compiledRule, req RequestInfo) *compiledRule {
+	for _, rule := range rules {
+		if rule.accepts(req) {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package prometheus
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRouteTriePrecedence(t *testing.T) {
+	rules := []PatternRule{
+		// declared from the least to the most specific, the trie must not depend on order
+		{Route: "/users/*rest", Name: "catch_all"},
+		{Route: "/users/{name}", Name: "untyped"},
+		{Route: "/users/{id:int}", Name: "typed"},
+		{Route: "/users/me", Name: "static"},
+		{Route: "/users/{id:int}/posts", Name: "typed_posts"},
+		{Route: "/users/{name}/settings", Name: "untyped_settings"},
+	}
+
+	rs, err := compileRuleSet(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/users/me", "static"},
+		{"/users/42", "typed"},
+		{"/users/bob", "untyped"},
+		{"/users/bob/avatar", "catch_all"},
+		{"/users/42/posts", "typed_posts"},
+		// the typed branch has no settings child, the untyped branch is tried next
+		{"/users/42/settings", "untyped_settings"},
+		// dead ends in the typed branch fall back to the catch-all
+		{"/users/42/comments", "catch_all"},
+		{"/users", ""},
+		{"/accounts/1", ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if rule := rs.lookup(RequestInfo{Method: "GET", Path: tt.path}); rule != nil {
+			got = rule.name
+		}
+		if got != tt.want {
+			t.Errorf("lookup(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteTrieScopedLeaf(t *testing.T) {
+	rules := []PatternRule{
+		{Route: "/orders/{id:int}", Name: "order_update", Methods: []string{"put", "PATCH"}},
+		{Route: "/orders/{id:int}", Name: "order_admin", Host: "admin.example.com"},
+		{Route: "/orders/{id:int}", Name: "order_tenant", Host: "*.example.com"},
+		{Route: "/orders/{id:int}", Name: "order"},
+	}
+
+	rs, err := compileRuleSet(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.scoped {
+		t.Fatal("rule set with method and host rules is not scoped")
+	}
+
+	tests := []struct {
+		method string
+		host   string
+		want   string
+	}{
+		{"PUT", "api.example.com", "order_update"},
+		{"PATCH", "", "order_update"},
+		{"GET", "admin.example.com", "order_admin"},
+		{"GET", "shop.example.com", "order_tenant"},
+		// the wildcard only matches subdomains
+		{"GET", "example.com", "order"},
+		{"GET", "", "order"},
+	}
+
+	for _, tt := range tests {
+		req := RequestInfo{Method: tt.method, Host: tt.host, Path: "/orders/7"}
+		rule := rs.lookup(req)
+		if rule == nil {
+			t.Fatalf("lookup(%+v) found no rule", req)
+		}
+		if rule.name != tt.want {
+			t.Errorf("lookup(%s %s) = %q, want %q", tt.method, tt.host, rule.name, tt.want)
+		}
+	}
+}
+
+func TestRouteTrieBeforeRegex(t *testing.T) {
+	rs, err := compileRuleSet([]PatternRule{
+		{Pattern: "^/api/.*", Name: "regex"},
+		{Route: "/api/items/{id:int}", Name: "route"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		"/api/items/1":   "route",
+		"/api/items/abc": "regex",
+	} {
+		rule := rs.lookup(RequestInfo{Path: path})
+		if rule == nil || rule.name != want {
+			t.Errorf("lookup(%q) = %v, want %q", path, rule, want)
+		}
+	}
+}
+
+// benchmarkRules returns n rules matching /api/resource<i>/<id>, either as
+// route templates or as the equivalent regexes scanned in order
+func benchmarkRules(n int, routes bool) []PatternRule {
+	rules := make([]PatternRule, 0, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("resource_%d", i)
+		if routes {
+			rules = append(rules, PatternRule{Route: fmt.Sprintf("/api/resource%d/{id:int}", i), Name: name})
+		} else {
+			rules = append(rules, PatternRule{Pattern: fmt.Sprintf("^/api/resource%d/[0-9]+$", i), Name: name})
+		}
+	}
+	return rules
+}
+
+func benchmarkMatch(b *testing.B, routes bool) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("rules=%d", n), func(b *testing.B) {
+			rs, err := compileRuleSet(benchmarkRules(n, routes))
+			if err != nil {
+				b.Fatal(err)
+			}
+			// the last rule is the worst case for a linear scan
+			req := RequestInfo{Method: "GET", Path: fmt.Sprintf("/api/resource%d/12345", n-1)}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if rs.lookup(req) == nil {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMatchLinear(b *testing.B) {
+	benchmarkMatch(b, false)
+}
+
+func BenchmarkMatchTrie(b *testing.B) {
+	benchmarkMatch(b, true)
+}
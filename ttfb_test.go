@@ -0,0 +1,102 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// histogramSum returns the sample sum of a histogram series, -1 if it is missing
+func histogramSum(t *testing.T, p *Plugin, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	m := findMetric(gather(t, p), name, labels)
+	if m == nil || m.GetHistogram() == nil {
+		return -1
+	}
+	return m.GetHistogram().GetSampleSum()
+}
+
+func TestTimeToFirstByte(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	labels := map[string]string{"method": "GET", "endpoint": "other"}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		// slowStart reports whether the delay is before the first byte
+		slowStart bool
+	}{
+		{"slow to start", func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(delay)
+			_, _ = w.Write([]byte("page"))
+		}, true},
+		{"slow to stream", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			time.Sleep(delay)
+			_, _ = w.Write([]byte("page"))
+		}, false},
+		{"flushed headers", func(w http.ResponseWriter, _ *http.Request) {
+			w.(http.Flusher).Flush()
+			time.Sleep(delay)
+		}, false},
+		// early hints are not the response
+		{"after early hints", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusEarlyHints)
+			time.Sleep(delay)
+			w.WriteHeader(http.StatusOK)
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, nil)
+			serveRequest(p, tt.handler, httptest.NewRequest(http.MethodGet, "/page", nil))
+
+			ttfb := histogramSum(t, p, "rr_http_time_to_first_byte_seconds", labels)
+			total := histogramSum(t, p, "rr_http_duration_by_endpoint_seconds", labels)
+			if ttfb < 0 || total < 0 {
+				t.Fatalf("time_to_first_byte %v, duration %v; want both recorded", ttfb, total)
+			}
+
+			if tt.slowStart && ttfb < delay.Seconds() {
+				t.Errorf("time_to_first_byte = %v, want at least %v", ttfb, delay.Seconds())
+			}
+			if !tt.slowStart && total-ttfb < delay.Seconds() {
+				t.Errorf("time_to_first_byte = %v with duration %v, want the first byte %v before the end", ttfb, total, delay.Seconds())
+			}
+		})
+	}
+}
+
+func TestTimeToFirstByteWithoutResponse(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	rec := serveRequest(p, func(http.ResponseWriter, *http.Request) {}, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	families := gather(t, p)
+	if _, ok := families["rr_http_time_to_first_byte_seconds"]; ok {
+		t.Error("time_to_first_byte recorded for a request that wrote nothing")
+	}
+	if v := metricValue(families, "rr_http_request_total", map[string]string{"status": "200"}); v != 1 {
+		t.Errorf("request_total{status=200} = %v, want 1", v)
+	}
+}
+
+func TestTimeToFirstByteDisabled(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectTTFB = false
+	})
+
+	serveRequest(p, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("page"))
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if _, ok := gather(t, p)["rr_http_time_to_first_byte_seconds"]; ok {
+		t.Error("time_to_first_byte recorded with collect_ttfb disabled")
+	}
+}
@@ -0,0 +1,118 @@
+package prometheus
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// upgradeTracker records connections taken over by the handler, e.g.
+// WebSockets. Their lifetime is not a request duration, so it goes to a
+// separate histogram once the connection is closed
+type upgradeTracker struct {
+	open     prometheus.Gauge
+	duration *prometheus.HistogramVec
+}
+
+func newUpgradeTracker(buckets []float64) *upgradeTracker {
+	return &upgradeTracker{
+		open: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "upgraded_connections",
+			Help:      "Current number of hijacked connections, e.g. WebSockets, not closed yet.",
+		}),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "upgraded_connection_duration_seconds",
+				Help:      "Lifetime of hijacked connections, from the hijack until the connection was closed.",
+				Buckets:   buckets,
+			},
+			[]string{"endpoint"},
+		),
+	}
+}
+
+func (u *upgradeTracker) collectors() []prometheus.Collector {
+	return []prometheus.Collector{u.open, u.duration}
+}
+
+// hijackedConn tracks a hijacked connection until it is closed. The endpoint
+// is only known once the handler returns, while WebSocket handlers usually
+// close the connection before that, so whichever happens last records the lifetime
+type hijackedConn struct {
+	net.Conn
+
+	tracker *upgradeTracker
+	opened  time.Time
+
+	mu       sync.Mutex
+	endpoint string
+	resolved bool
+	closed   time.Time
+}
+
+func (u *upgradeTracker) track(conn net.Conn) *hijackedConn {
+	u.open.Inc()
+	return &hijackedConn{Conn: conn, tracker: u, opened: time.Now()}
+}
+
+// Close closes the connection, the lifetime is recorded on the first call
+func (c *hijackedConn) Close() error {
+	err := c.Conn.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed.IsZero() {
+		return err
+	}
+	c.closed = time.Now()
+	c.tracker.open.Dec()
+	if c.resolved {
+		c.observe()
+	}
+
+	return err
+}
+
+// resolve sets the endpoint of the connection once the handler returned
+func (c *hijackedConn) resolve(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.endpoint = endpoint
+	c.resolved = true
+	if !c.closed.IsZero() {
+		c.observe()
+	}
+}
+
+// observe records the lifetime. Callers must hold the lock
+func (c *hijackedConn) observe() {
+	c.tracker.duration.WithLabelValues(c.endpoint).Observe(c.closed.Sub(c.opened).Seconds())
+}
+
+// hijack lets the handler take over the connection. The connection is
+// tracked as upgraded if the plugin records upgrades
+func (w *writer) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// internal headers must not leak into a handshake written by the handler
+	w.captureHeaders()
+
+	conn, rw, err := w.w.(http.Hijacker).Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+
+	w.hijacked = true
+	if w.upgrades != nil {
+		w.conn = w.upgrades.track(conn)
+		conn = w.conn
+	}
+
+	return conn, rw, nil
+}
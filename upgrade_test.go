@@ -0,0 +1,228 @@
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the handler behind the middleware, done receives a
+// value once the middleware returned
+func newTestServer(t *testing.T, p *Plugin, handler http.HandlerFunc) (*httptest.Server, chan struct{}) {
+	t.Helper()
+
+	done := make(chan struct{}, 1)
+	mw := p.Middleware(handler)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { done <- struct{}{} }()
+		mw.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, done
+}
+
+// upgrade sends an upgrade request over a new connection and reads the handshake
+func upgrade(t *testing.T, srv *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+srv.Listener.Addr().String()+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d, want 101", resp.StatusCode)
+	}
+	return conn, br
+}
+
+// hijackHandler writes the handshake on the hijacked connection and hands it to serve
+func hijackHandler(t *testing.T, serve func(net.Conn, *bufio.ReadWriter)) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = rw.Flush()
+		serve(conn, rw)
+	}
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	srv, done := newTestServer(t, p, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RR-Worker-Start", strconv.FormatInt(time.Now().UnixMicro(), 10))
+		hijackHandler(t, func(conn net.Conn, rw *bufio.ReadWriter) {
+			defer conn.Close()
+			line, _ := rw.ReadString('\n')
+			_, _ = rw.WriteString(line)
+			_ = rw.Flush()
+		})(w, r)
+	})
+
+	conn, br := upgrade(t, srv, "/ws")
+	if _, err := io.WriteString(conn, "ping\n"); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := br.ReadString('\n'); err != nil || line != "ping\n" {
+		t.Fatalf("echo %q, %v", line, err)
+	}
+	<-done
+
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_request_total", map[string]string{"status": "101"}); v != 1 {
+		t.Errorf("request_total{status=101} = %v, want 1", v)
+	}
+	if v := metricValue(families, "rr_http_upgraded_connections", nil); v != 0 {
+		t.Errorf("upgraded_connections = %v, want 0", v)
+	}
+	if v := metricValue(families, "rr_http_upgraded_connection_duration_seconds", map[string]string{"endpoint": "other"}); v != 1 {
+		t.Errorf("upgraded_connection_duration_seconds count = %v, want 1", v)
+	}
+
+	// the socket lifetime is not a response time, the queue wait before the handshake is
+	if v := metricValue(families, "rr_http_queue_time_seconds", map[string]string{"endpoint": "other"}); v != 1 {
+		t.Errorf("queue_time_seconds count = %v, want 1", v)
+	}
+	for _, name := range []string{
+		"rr_http_request_duration_seconds",
+		"rr_http_duration_by_endpoint_seconds",
+		"rr_http_processing_time_seconds",
+		"rr_http_time_to_first_byte_seconds",
+	} {
+		if _, ok := families[name]; ok {
+			t.Errorf("%s recorded for an upgraded connection", name)
+		}
+	}
+}
+
+func TestMiddlewareHijackOutlivesHandler(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.EndpointPatterns.Rules = []PatternRule{{Pattern: "^/ws$", Name: "ws"}}
+	})
+
+	conns := make(chan net.Conn, 1)
+	srv, done := newTestServer(t, p, hijackHandler(t, func(conn net.Conn, _ *bufio.ReadWriter) {
+		conns <- conn
+	}))
+
+	upgrade(t, srv, "/ws")
+	<-done
+
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_upgraded_connections", nil); v != 1 {
+		t.Errorf("upgraded_connections = %v while open, want 1", v)
+	}
+	if _, ok := families["rr_http_upgraded_connection_duration_seconds"]; ok {
+		t.Error("connection duration recorded before the connection was closed")
+	}
+
+	conn := <-conns
+	_ = conn.Close()
+	_ = conn.Close()
+
+	families = gather(t, p)
+	if v := metricValue(families, "rr_http_upgraded_connections", nil); v != 0 {
+		t.Errorf("upgraded_connections = %v after close, want 0", v)
+	}
+	if v := metricValue(families, "rr_http_upgraded_connection_duration_seconds", map[string]string{"endpoint": "ws"}); v != 1 {
+		t.Errorf("upgraded_connection_duration_seconds{endpoint=ws} count = %v, want 1", v)
+	}
+}
+
+func TestMiddlewareEarlyHintsHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		// late sets the route header after the early hints
+		late bool
+	}{
+		{"route set before the hints", false},
+		{"route set after the hints", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(t, func(cfg *Config) {
+				cfg.RouteHeader.Enabled = true
+				cfg.RouteHeader.Allow = []string{`^app_`}
+			})
+
+			srv, done := newTestServer(t, p, func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Link", "</app.css>; rel=preload; as=style")
+				if !tt.late {
+					w.Header().Set("X-RR-Route", "app_page")
+				}
+				w.WriteHeader(http.StatusEarlyHints)
+				if tt.late {
+					w.Header().Set("X-RR-Route", "app_page")
+				}
+				_, _ = w.Write([]byte("page"))
+			})
+
+			var hints []textproto.MIMEHeader
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					if code == http.StatusEarlyHints {
+						hints = append(hints, header)
+					}
+					return nil
+				},
+			}
+			req, err := http.NewRequestWithContext(httptrace.WithClientTrace(t.Context(), trace), http.MethodGet, srv.URL+"/page", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			<-done
+
+			if len(hints) != 1 {
+				t.Fatalf("got %d early hints, want 1", len(hints))
+			}
+			if hints[0].Get("Link") == "" {
+				t.Error("early hints lost the Link header")
+			}
+			if v := hints[0].Get("X-RR-Route"); v != "" {
+				t.Errorf("early hints leaked the route header %q", v)
+			}
+			if v := resp.Header.Get("X-RR-Route"); v != "" {
+				t.Errorf("response leaked the route header %q", v)
+			}
+
+			labels := map[string]string{"endpoint": "app_page", "status": "200"}
+			if v := metricValue(gather(t, p), "rr_http_requests_by_endpoint_total", labels); v != 1 {
+				t.Errorf("requests_by_endpoint_total{endpoint=app_page} = %v, want 1", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// upstreamQueue reads the time a proxy received the request from headers
+// such as X-Request-Start and derives how long it waited in front of RoadRunner
+type upstreamQueue struct {
+	headers []string
+	trusted []netip.Prefix
+	maxSkew time.Duration
+	maxAge  time.Duration
+}
+
+// newUpstreamQueue builds the header reader. Returns nil if disabled
+func newUpstreamQueue(config UpstreamQueueTimeConfig) (*upstreamQueue, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	trusted, err := parseCIDRs(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := config.Headers
+	if len(headers) == 0 {
+		headers = []string{"X-Request-Start", "X-Queue-Start"}
+	}
+
+	maxSkew := config.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = time.Second
+	}
+
+	maxAge := config.MaxAge
+	if maxAge <= 0 {
+		maxAge = 5 * time.Minute
+	}
+
+	u := &upstreamQueue{
+		headers: make([]string, 0, len(headers)),
+		trusted: trusted,
+		maxSkew: maxSkew,
+		maxAge:  maxAge,
+	}
+	for _, header := range headers {
+		u.headers = append(u.headers, http.CanonicalHeaderKey(header))
+	}
+
+	return u, nil
+}
+
+// queueTime returns the time between the proxy stamping the request and its
+// arrival. Headers are only trusted from the configured proxies
+func (u *upstreamQueue) queueTime(r *http.Request, arrival time.Time) (time.Duration, bool) {
+	addr, ok := remoteAddr(r)
+	if !ok || !containsAddr(u.trusted, addr) {
+		return 0, false
+	}
+
+	for _, header := range u.headers {
+		start, ok := parseUnixTimestamp(r.Header.Get(header))
+		if !ok {
+			continue
+		}
+
+		wait := arrival.Sub(start)
+		switch {
+		case wait < -u.maxSkew, wait > u.maxAge:
+			return 0, false
+		case wait < 0:
+			// proxy clock slightly ahead
+			return 0, true
+		default:
+			return wait, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,147 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestUpstream(t *testing.T, config UpstreamQueueTimeConfig) *upstreamQueue {
+	t.Helper()
+
+	config.Enabled = true
+	u, err := newUpstreamQueue(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func upstreamRequest(remote string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remote
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	return r
+}
+
+func TestUpstreamQueueTime(t *testing.T) {
+	u := newTestUpstream(t, UpstreamQueueTimeConfig{TrustedProxies: []string{"10.0.0.0/8", "::1"}})
+
+	arrival := time.Unix(1700000000, 0)
+	stamp := func(d time.Duration) string {
+		return "t=" + strconv.FormatInt(arrival.Add(-d).UnixMicro(), 10)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		wait    time.Duration
+		ok      bool
+	}{
+		{"x-request-start", "10.0.0.1:1234", map[string]string{"X-Request-Start": stamp(50 * time.Millisecond)}, 50 * time.Millisecond, true},
+		{"x-queue-start", "10.0.0.1:1234", map[string]string{"X-Queue-Start": stamp(20 * time.Millisecond)}, 20 * time.Millisecond, true},
+		{"ipv6 proxy", "[::1]:1234", map[string]string{"X-Request-Start": stamp(time.Millisecond)}, time.Millisecond, true},
+		{"untrusted peer", "192.0.2.1:1234", map[string]string{"X-Request-Start": stamp(time.Millisecond)}, 0, false},
+		{"no header", "10.0.0.1:1234", nil, 0, false},
+		// an unparsable header falls through to the next one
+		{"invalid first header", "10.0.0.1:1234", map[string]string{
+			"X-Request-Start": "garbage",
+			"X-Queue-Start":   stamp(30 * time.Millisecond),
+		}, 30 * time.Millisecond, true},
+		{"small skew", "10.0.0.1:1234", map[string]string{"X-Request-Start": stamp(-500 * time.Millisecond)}, 0, true},
+		{"large skew", "10.0.0.1:1234", map[string]string{"X-Request-Start": stamp(-2 * time.Second)}, 0, false},
+		{"too old", "10.0.0.1:1234", map[string]string{"X-Request-Start": stamp(10 * time.Minute)}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, ok := u.queueTime(upstreamRequest(tt.remote, tt.headers), arrival)
+			if ok != tt.ok || wait != tt.wait {
+				t.Errorf("queueTime = %v, %v; want %v, %v", wait, ok, tt.wait, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUpstreamQueueConfig(t *testing.T) {
+	if u, err := newUpstreamQueue(UpstreamQueueTimeConfig{}); u != nil || err != nil {
+		t.Errorf("disabled = %v, %v; want nil", u, err)
+	}
+	if _, err := newUpstreamQueue(UpstreamQueueTimeConfig{Enabled: true, TrustedProxies: []string{"proxy"}}); err == nil {
+		t.Error("invalid trusted proxy accepted")
+	}
+
+	// custom headers are canonicalized, limits apply as configured
+	u := newTestUpstream(t, UpstreamQueueTimeConfig{
+		Headers:        []string{"x-edge-start"},
+		TrustedProxies: []string{"10.0.0.1"},
+		MaxAge:         time.Second,
+	})
+	arrival := time.Unix(1700000000, 0)
+	r := upstreamRequest("10.0.0.1:1234", map[string]string{
+		"X-Edge-Start":    strconv.FormatInt(arrival.Add(-100*time.Millisecond).UnixMilli(), 10),
+		"X-Request-Start": strconv.FormatInt(arrival.Add(-200*time.Millisecond).UnixMilli(), 10),
+	})
+	if wait, ok := u.queueTime(r, arrival); !ok || wait != 100*time.Millisecond {
+		t.Errorf("queueTime = %v, %v; want 100ms from the custom header", wait, ok)
+	}
+	r.Header.Set("X-Edge-Start", strconv.FormatInt(arrival.Add(-2*time.Second).UnixMilli(), 10))
+	if _, ok := u.queueTime(r, arrival); ok {
+		t.Error("stamp older than max_age accepted")
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	prefixes, err := parseCIDRs([]string{"10.1.2.3/8", " 192.0.2.7 ", "::ffff:198.51.100.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, want := range map[string]bool{
+		"10.200.0.1:80":        true,
+		"192.0.2.7:80":         true,
+		"192.0.2.8:80":         false,
+		"198.51.100.1:80":      true,
+		"[::ffff:10.0.0.1]:80": true,
+		"[2001:db8::1]:80":     true,
+		"[2001:db9::1]:80":     false,
+		"unix":                 false,
+	} {
+		ip, ok := remoteAddr(&http.Request{RemoteAddr: addr})
+		if got := ok && containsAddr(prefixes, ip); got != want {
+			t.Errorf("%s contained = %v, want %v", addr, got, want)
+		}
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "10.0.0", "host"} {
+		if _, err = parseCIDRs([]string{value}); err == nil {
+			t.Errorf("parseCIDRs(%q) succeeded", value)
+		}
+	}
+}
+
+func TestMiddlewareUpstreamQueueTime(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.UpstreamQueueTime = UpstreamQueueTimeConfig{Enabled: true, TrustedProxies: []string{"192.0.2.0/24"}}
+	})
+
+	// httptest requests come from 192.0.2.1
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Request-Start", strconv.FormatInt(time.Now().Add(-10*time.Millisecond).UnixMicro(), 10))
+	serveRequest(p, func(http.ResponseWriter, *http.Request) {}, r)
+	serveRequest(p, func(http.ResponseWriter, *http.Request) {}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	families := gather(t, p)
+	m := findMetric(families, "rr_http_upstream_queue_time_seconds", map[string]string{"method": "GET", "endpoint": "other"})
+	if m == nil {
+		t.Fatal("no upstream_queue_time series")
+	}
+	if h := m.GetHistogram(); h.GetSampleCount() != 1 || h.GetSampleSum() < 0.01 {
+		t.Errorf("upstream_queue_time count %d, sum %v; want 1 sample of at least 10ms", h.GetSampleCount(), h.GetSampleSum())
+	}
+}
@@ -0,0 +1,146 @@
+package prometheus
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/roadrunner-server/endure/v2/dep"
+)
+
+// Worker states as reported by the RoadRunner pool
+const (
+	workerStateReady   string = "ready"
+	workerStateWorking string = "working"
+)
+
+// workerState is a single worker as reported by the informer
+type workerState struct {
+	pid      int64
+	status   string
+	numExecs uint64
+	memory   uint64
+}
+
+// workerStates returns the current workers of the informer
+func workerStates(informer Informer) []workerState {
+	list := informer.Workers()
+
+	states := make([]workerState, 0, len(list))
+	for _, st := range list {
+		if st == nil {
+			continue
+		}
+		states = append(states, workerState{
+			pid:      st.Pid,
+			status:   st.StatusStr,
+			numExecs: st.NumExecs,
+			memory:   st.MemoryUsage,
+		})
+	}
+
+	return states
+}
+
+// Collects declares the plugins the endure container injects: informers
+// exposing their worker pool. CollectWorkers keeps the http plugin only
+func (p *Plugin) Collects() []*dep.In {
+	return []*dep.In{
+		dep.Fits(p.CollectWorkers, (*Informer)(nil)),
+	}
+}
+
+// CollectWorkers registers the http plugin worker pool. It feeds the worker
+// gauges instead of the in-flight estimate; informers of other plugins are
+// ignored, their workers do not serve http requests
+func (p *Plugin) CollectWorkers(plugin any) {
+	informer, ok := plugin.(Informer)
+	if !ok || !p.config.CollectWorkerInfo || informer.Name() != httpPluginName {
+		return
+	}
+
+	p.workersMu.Lock()
+	p.informer = informer
+	p.workersMu.Unlock()
+}
+
+// workerPool returns the worker pool state for the gauges: the last state
+// reported by the informer, or an estimate from in-flight requests without one
+func (p *Plugin) workerPool() WorkerPoolStats {
+	if stats := p.workerStats.Load(); stats != nil {
+		return *stats
+	}
+
+	busy := p.inflight.busyNow()
+	workers := int(p.inflight.workers)
+	return WorkerPoolStats{
+		Active:      int(busy),
+		Idle:        workers - int(busy),
+		Total:       workers,
+		UpdatedAt:   time.Now(),
+		Utilization: p.inflight.utilization(float64(busy)),
+	}
+}
+
+// workerPoolStats aggregates the workers of the http pool. Returns nil if
+// no informer is registered
+func (p *Plugin) workerPoolStats() (*WorkerPoolStats, []workerState) {
+	p.workersMu.Lock()
+	informer := p.informer
+	p.workersMu.Unlock()
+
+	if informer == nil {
+		return nil, nil
+	}
+
+	workers := workerStates(informer)
+
+	stats := &WorkerPoolStats{
+		Total:     len(workers),
+		UpdatedAt: time.Now(),
+	}
+	for _, w := range workers {
+		switch w.status {
+		case workerStateWorking:
+			stats.Active++
+		case workerStateReady:
+			stats.Idle++
+		}
+	}
+	if stats.Total > 0 {
+		stats.Utilization = float64(stats.Active) / float64(stats.Total) * 100
+	}
+
+	return stats, workers
+}
+
+// updateWorkerGauges exports per-worker gauges for up to max_worker_series
+// workers (lowest PIDs first) and removes the series of workers that are gone
+func (p *Plugin) updateWorkerGauges(workers []workerState) {
+	sort.Slice(workers, func(i, j int) bool { return workers[i].pid < workers[j].pid })
+	if len(workers) > p.config.MaxWorkerSeries {
+		workers = workers[:p.config.MaxWorkerSeries]
+	}
+
+	current := make(map[string]string, len(workers))
+	for _, w := range workers {
+		pid := strconv.FormatInt(w.pid, 10)
+		current[pid] = w.status
+
+		p.workerMemory.WithLabelValues(pid).Set(float64(w.memory))
+		p.workerJobs.WithLabelValues(pid).Set(float64(w.numExecs))
+		if state, ok := p.exportedWorkers[pid]; ok && state != w.status {
+			p.workerState.DeleteLabelValues(pid, state)
+		}
+		p.workerState.WithLabelValues(pid, w.status).Set(1)
+	}
+
+	for pid, state := range p.exportedWorkers {
+		if _, ok := current[pid]; !ok {
+			p.workerMemory.DeleteLabelValues(pid)
+			p.workerJobs.DeleteLabelValues(pid)
+			p.workerState.DeleteLabelValues(pid, state)
+		}
+	}
+	p.exportedWorkers = current
+}
@@ -0,0 +1,190 @@
+package prometheus
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/roadrunner-server/pool/state/process"
+)
+
+// fakeInformer is a worker pool whose state the test controls
+type fakeInformer struct {
+	name string
+
+	mu      sync.Mutex
+	workers []*process.State
+}
+
+func (f *fakeInformer) Name() string { return f.name }
+
+func (f *fakeInformer) Workers() []*process.State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.workers
+}
+
+func (f *fakeInformer) set(workers ...*process.State) {
+	f.mu.Lock()
+	f.workers = workers
+	f.mu.Unlock()
+}
+
+func TestCollects(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectWorkerInfo = true
+	})
+
+	in := p.Collects()
+	if len(in) != 1 {
+		t.Fatalf("Collects() = %d dependencies, want 1", len(in))
+	}
+	if !reflect.TypeOf(&fakeInformer{name: "http"}).Implements(in[0].Type) {
+		t.Fatalf("informer does not fit %s", in[0].Type)
+	}
+	if reflect.TypeOf(p).Implements(in[0].Type) {
+		t.Error("the plugin collects itself")
+	}
+
+	// the container calls back with every plugin implementing the interface
+	httpPool := &fakeInformer{name: "http"}
+	for _, informer := range []*fakeInformer{{name: "jobs"}, httpPool, {name: "grpc"}} {
+		in[0].Callback(informer)
+	}
+	if p.informer != httpPool {
+		t.Errorf("informer = %+v, want the http plugin", p.informer)
+	}
+}
+
+func TestCollectWorkersIgnoresOtherPools(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectWorkerInfo = true
+	})
+
+	httpPool := &fakeInformer{name: "http"}
+	httpPool.set(&process.State{Pid: 1, StatusStr: workerStateWorking})
+	jobs := &fakeInformer{name: "jobs"}
+	jobs.set(
+		&process.State{Pid: 2, StatusStr: workerStateWorking},
+		&process.State{Pid: 3, StatusStr: workerStateReady},
+	)
+	p.CollectWorkers(jobs)
+	p.CollectWorkers(httpPool)
+	p.updateWorkerMetrics()
+
+	families := gather(t, p)
+	if v := metricValue(families, "rr_http_active_workers", nil); v != 1 {
+		t.Errorf("active_workers = %v, want the http pool only", v)
+	}
+	if v := metricValue(families, "rr_http_idle_workers", nil); v != 0 {
+		t.Errorf("idle_workers = %v, want the http pool only", v)
+	}
+	if m := findMetric(families, "rr_http_worker_state", map[string]string{"pid": "2"}); m != nil {
+		t.Error("worker_state exported for a jobs worker")
+	}
+}
+
+func TestCollectWorkersDisabled(t *testing.T) {
+	p := newTestPlugin(t, nil)
+	p.CollectWorkers(&fakeInformer{name: "http"})
+	if p.informer != nil {
+		t.Error("informer registered with collect_worker_info disabled")
+	}
+}
+
+func TestWorkerGauges(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectWorkerInfo = true
+		cfg.MaxWorkerSeries = 2
+	})
+
+	informer := &fakeInformer{name: "http"}
+	p.CollectWorkers(informer)
+
+	informer.set(
+		&process.State{Pid: 30, StatusStr: workerStateReady, NumExecs: 3, MemoryUsage: 3000},
+		&process.State{Pid: 10, StatusStr: workerStateWorking, NumExecs: 1, MemoryUsage: 1000},
+		nil,
+		&process.State{Pid: 20, StatusStr: workerStateWorking, NumExecs: 2, MemoryUsage: 2000},
+	)
+	p.updateWorkerMetrics()
+
+	families := gather(t, p)
+	for name, want := range map[string]float64{
+		"rr_http_active_workers":             2,
+		"rr_http_idle_workers":               1,
+		"rr_http_worker_utilization_percent": float64(2) / float64(3) * 100,
+	} {
+		if v := metricValue(families, name, nil); v != want {
+			t.Errorf("%s = %v, want %v", name, v, want)
+		}
+	}
+
+	// only the lowest max_worker_series PIDs are exported
+	for pid, memory := range map[string]float64{"10": 1000, "20": 2000, "30": -1} {
+		if v := metricValue(families, "rr_http_worker_memory_bytes", map[string]string{"pid": pid}); v != memory {
+			t.Errorf("worker_memory_bytes{pid=%s} = %v, want %v", pid, v, memory)
+		}
+	}
+
+	// worker 10 is gone, 20 became ready, 5 started
+	informer.set(
+		&process.State{Pid: 5, StatusStr: workerStateReady, NumExecs: 0, MemoryUsage: 500},
+		&process.State{Pid: 20, StatusStr: workerStateReady, NumExecs: 4, MemoryUsage: 2500},
+	)
+	p.updateWorkerMetrics()
+
+	families = gather(t, p)
+	for _, name := range []string{"rr_http_worker_memory_bytes", "rr_http_worker_jobs_executed", "rr_http_worker_state"} {
+		if m := findMetric(families, name, map[string]string{"pid": "10"}); m != nil {
+			t.Errorf("%s of the stale worker 10 was not deleted", name)
+		}
+		if n := len(families[name].GetMetric()); n != 2 {
+			t.Errorf("%s has %d series, want 2", name, n)
+		}
+	}
+	if v := metricValue(families, "rr_http_worker_jobs_executed", map[string]string{"pid": "20"}); v != 4 {
+		t.Errorf("worker_jobs_executed{pid=20} = %v, want 4", v)
+	}
+	// a state change replaces the series instead of adding one
+	if m := findMetric(families, "rr_http_worker_state", map[string]string{"pid": "20", "state": workerStateWorking}); m != nil {
+		t.Error("previous state series of worker 20 was not deleted")
+	}
+	if v := metricValue(families, "rr_http_worker_state", map[string]string{"pid": "20", "state": workerStateReady}); v != 1 {
+		t.Errorf("worker_state{pid=20,state=ready} = %v, want 1", v)
+	}
+	if v := metricValue(families, "rr_http_active_workers", nil); v != 0 {
+		t.Errorf("active_workers = %v, want 0", v)
+	}
+}
+
+func TestWorkerEstimateWithoutInformer(t *testing.T) {
+	p := newTestPlugin(t, func(cfg *Config) {
+		cfg.CollectWorkerInfo = true
+	})
+	p.inflight = newInflightTracker(4)
+
+	// five requests in flight on four workers, one of them queued
+	for i := 0; i < 5; i++ {
+		p.inflight.add(1)
+	}
+	families := gather(t, p)
+	for name, want := range map[string]float64{
+		"rr_http_active_workers":             4,
+		"rr_http_idle_workers":               0,
+		"rr_http_worker_utilization_percent": 100,
+	} {
+		if v := metricValue(families, name, nil); v != want {
+			t.Errorf("%s = %v, want %v", name, v, want)
+		}
+	}
+
+	// once an informer reported, its state replaces the estimate
+	informer := &fakeInformer{name: "http"}
+	informer.set(&process.State{Pid: 1, StatusStr: workerStateReady})
+	p.CollectWorkers(informer)
+	p.updateWorkerMetrics()
+	if v := metricValue(gather(t, p), "rr_http_active_workers", nil); v != 0 {
+		t.Errorf("active_workers = %v, want the informer state", v)
+	}
+}
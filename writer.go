@@ -1,43 +1,148 @@
 package prometheus
 
 import (
+	"bufio"
+	"io"
+	"net"
 	"net/http"
 	"time"
 )
 
-// writer wraps http.ResponseWriter to track response metrics
+// writer wraps http.ResponseWriter to track response metrics. Handlers get
+// it through responseWriter, which only exposes the optional interfaces
+// the wrapped writer implements
 type writer struct {
 	w http.ResponseWriter
 
+	// wrappers caches the value handed to handlers per capability set,
+	// they only refer to the writer itself and survive reset
+	wrappers [capAll + 1]http.ResponseWriter
+
 	// Response tracking
 	code         int
 	bytesWritten int64
+	writeErr     error // first error writing the response
+
+	// Connection taken over by the handler, e.g. for WebSockets
+	hijacked bool
+	upgrades *upgradeTracker
+	conn     *hijackedConn
+
+	// probe is the endpoint matched before the request was served, if any
+	probe *endpointProbe
 
 	// Timing tracking
-	arrivalTime  time.Time
-	queueStart   time.Time
-	processStart time.Time
+	arrivalTime time.Time
+	firstByte   time.Time
+
+	// Flushes of streamed responses
+	stream streamState
 
-	// Request tracking
-	requestSize int64
+	// Internal response headers captured and removed before they reach the client
+	routeHeader       string
+	route             string
+	workerStartHeader string
+	workerStart       string
+	headersCaptured   bool
+}
+
+// captureHeaders extracts internal headers set by the worker and strips them
+// from the response. Must run before the headers are sent
+func (w *writer) captureHeaders() {
+	if w.headersCaptured {
+		return
+	}
+	w.headersCaptured = true
+	w.takeHeaders()
 }
 
-func (w *writer) Flush() {
-	if fl, ok := w.w.(http.Flusher); ok {
-		fl.Flush()
+// takeHeaders moves the internal headers set so far out of the response.
+// A value set again later replaces the one taken
+func (w *writer) takeHeaders() {
+	if w.routeHeader == "" && w.workerStartHeader == "" {
+		return
+	}
+
+	h := w.w.Header()
+	if w.routeHeader != "" {
+		if v := h.Get(w.routeHeader); v != "" {
+			w.route = v
+		}
+		h.Del(w.routeHeader)
+	}
+	if w.workerStartHeader != "" {
+		if v := h.Get(w.workerStartHeader); v != "" {
+			w.workerStart = v
+		}
+		h.Del(w.workerStartHeader)
 	}
 }
 
+// commit records when the response started, i.e. when the headers were
+// committed. Without a WriteHeader call the status is an implicit 200, as
+// in net/http. Later calls keep the first timestamp
+func (w *writer) commit() {
+	if w.code == -1 {
+		w.code = http.StatusOK
+	}
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+	}
+}
+
+func (w *writer) flush() {
+	w.captureHeaders()
+	w.commit()
+	w.w.(http.Flusher).Flush()
+	w.stream.flushed(w)
+}
+
+// readFrom keeps the sendfile path of the wrapped writer, e.g. for static files
+func (w *writer) readFrom(src io.Reader) (int64, error) {
+	w.captureHeaders()
+	w.commit()
+	n, err := w.w.(io.ReaderFrom).ReadFrom(src)
+	w.bytesWritten += n
+	w.failed(err)
+	return n, err
+}
+
+// failed keeps the first write error of the response
+func (w *writer) failed(err error) {
+	if err != nil && w.writeErr == nil {
+		w.writeErr = err
+	}
+}
+
+func (w *writer) push(target string, opts *http.PushOptions) error {
+	return w.w.(http.Pusher).Push(target, opts)
+}
+
 func (w *writer) WriteHeader(code int) {
+	// informational responses such as 103 Early Hints precede the final one,
+	// they are sent with the headers set so far
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		if !w.headersCaptured {
+			w.takeHeaders()
+		}
+		w.w.WriteHeader(code)
+		return
+	}
+
+	w.captureHeaders()
 	if w.code == -1 {
 		w.code = code
 	}
+	w.commit()
 	w.w.WriteHeader(code)
 }
 
 func (w *writer) Write(b []byte) (int, error) {
+	w.captureHeaders()
+	w.commit()
 	n, err := w.w.Write(b)
 	w.bytesWritten += int64(n)
+	w.failed(err)
 	return n, err
 }
 
@@ -45,13 +150,190 @@ func (w *writer) Header() http.Header {
 	return w.w.Header()
 }
 
+// Unwrap gives http.ResponseController access to the wrapped writer,
+// e.g. for SetWriteDeadline and EnableFullDuplex
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
+// Optional interfaces of the wrapped writer
+const (
+	capFlusher = 1 << iota
+	capHijacker
+	capReaderFrom
+	capPusher
+
+	capAll = capFlusher | capHijacker | capReaderFrom | capPusher
+)
+
+// Each type adds one optional interface to the writer it is converted from
+type (
+	flushWriter      writer
+	hijackWriter     writer
+	readerFromWriter writer
+	pushWriter       writer
+)
+
+func (f *flushWriter) Flush() { (*writer)(f).flush() }
+
+func (h *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return (*writer)(h).hijack()
+}
+
+func (r *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	return (*writer)(r).readFrom(src)
+}
+
+func (p *pushWriter) Push(target string, opts *http.PushOptions) error {
+	return (*writer)(p).push(target, opts)
+}
+
+// capabilities returns the optional interfaces of the wrapped writer
+func (w *writer) capabilities() int {
+	var caps int
+	if _, ok := w.w.(http.Flusher); ok {
+		caps |= capFlusher
+	}
+	if _, ok := w.w.(http.Hijacker); ok {
+		caps |= capHijacker
+	}
+	if _, ok := w.w.(io.ReaderFrom); ok {
+		caps |= capReaderFrom
+	}
+	if _, ok := w.w.(http.Pusher); ok {
+		caps |= capPusher
+	}
+	return caps
+}
+
+// responseWriter returns the writer as seen by handlers: it implements
+// exactly the optional interfaces the wrapped writer implements
+func (w *writer) responseWriter() http.ResponseWriter {
+	caps := w.capabilities()
+	if rw := w.wrappers[caps]; rw != nil {
+		return rw
+	}
+
+	f, h, r, p := (*flushWriter)(w), (*hijackWriter)(w), (*readerFromWriter)(w), (*pushWriter)(w)
+
+	var rw http.ResponseWriter
+	switch caps {
+	case 0:
+		rw = w
+	case capFlusher:
+		rw = struct {
+			*writer
+			http.Flusher
+		}{w, f}
+	case capHijacker:
+		rw = struct {
+			*writer
+			http.Hijacker
+		}{w, h}
+	case capFlusher | capHijacker:
+		rw = struct {
+			*writer
+			http.Flusher
+			http.Hijacker
+		}{w, f, h}
+	case capReaderFrom:
+		rw = struct {
+			*writer
+			io.ReaderFrom
+		}{w, r}
+	case capFlusher | capReaderFrom:
+		rw = struct {
+			*writer
+			http.Flusher
+			io.ReaderFrom
+		}{w, f, r}
+	case capHijacker | capReaderFrom:
+		rw = struct {
+			*writer
+			http.Hijacker
+			io.ReaderFrom
+		}{w, h, r}
+	case capFlusher | capHijacker | capReaderFrom:
+		rw = struct {
+			*writer
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, f, h, r}
+	case capPusher:
+		rw = struct {
+			*writer
+			http.Pusher
+		}{w, p}
+	case capFlusher | capPusher:
+		rw = struct {
+			*writer
+			http.Flusher
+			http.Pusher
+		}{w, f, p}
+	case capHijacker | capPusher:
+		rw = struct {
+			*writer
+			http.Hijacker
+			http.Pusher
+		}{w, h, p}
+	case capFlusher | capHijacker | capPusher:
+		rw = struct {
+			*writer
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, f, h, p}
+	case capReaderFrom | capPusher:
+		rw = struct {
+			*writer
+			io.ReaderFrom
+			http.Pusher
+		}{w, r, p}
+	case capFlusher | capReaderFrom | capPusher:
+		rw = struct {
+			*writer
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{w, f, r, p}
+	case capHijacker | capReaderFrom | capPusher:
+		rw = struct {
+			*writer
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, h, r, p}
+	default:
+		rw = struct {
+			*writer
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, f, h, r, p}
+	}
+
+	w.wrappers[caps] = rw
+	return rw
+}
+
 // reset prepares the writer for reuse from the pool
 func (w *writer) reset() {
 	w.code = -1
 	w.bytesWritten = 0
+	w.writeErr = nil
+	w.hijacked = false
+	w.upgrades = nil
+	w.conn = nil
 	w.arrivalTime = time.Time{}
-	w.queueStart = time.Time{}
-	w.processStart = time.Time{}
-	w.requestSize = 0
+	w.firstByte = time.Time{}
+	w.probe = nil
+	w.stream.reset()
+	w.routeHeader = ""
+	w.route = ""
+	w.workerStartHeader = ""
+	w.workerStart = ""
+	w.headersCaptured = false
 	w.w = nil
 }
@@ -0,0 +1,289 @@
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWriter counts the calls of the optional interfaces, rawWriter exposes
+// a subset of them
+type fakeWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	calls  map[string]int
+}
+
+func (f *fakeWriter) Header() http.Header         { return f.header }
+func (f *fakeWriter) Write(b []byte) (int, error) { return f.body.Write(b) }
+func (f *fakeWriter) WriteHeader(int)             {}
+
+func (f *fakeWriter) Flush() { f.calls["Flush"]++ }
+
+func (f *fakeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	f.calls["Hijack"]++
+	return nil, nil, errors.New("not a connection")
+}
+
+func (f *fakeWriter) ReadFrom(src io.Reader) (int64, error) {
+	f.calls["ReadFrom"]++
+	return f.body.ReadFrom(src)
+}
+
+func (f *fakeWriter) Push(string, *http.PushOptions) error {
+	f.calls["Push"]++
+	return nil
+}
+
+// rawWriter returns a writer implementing the optional interfaces in caps
+func rawWriter(f *fakeWriter, caps int) http.ResponseWriter {
+	type rw = http.ResponseWriter
+	switch caps {
+	case 0:
+		return struct{ rw }{f}
+	case capFlusher:
+		return struct {
+			rw
+			http.Flusher
+		}{f, f}
+	case capHijacker:
+		return struct {
+			rw
+			http.Hijacker
+		}{f, f}
+	case capFlusher | capHijacker:
+		return struct {
+			rw
+			http.Flusher
+			http.Hijacker
+		}{f, f, f}
+	case capReaderFrom:
+		return struct {
+			rw
+			io.ReaderFrom
+		}{f, f}
+	case capFlusher | capReaderFrom:
+		return struct {
+			rw
+			http.Flusher
+			io.ReaderFrom
+		}{f, f, f}
+	case capHijacker | capReaderFrom:
+		return struct {
+			rw
+			http.Hijacker
+			io.ReaderFrom
+		}{f, f, f}
+	case capFlusher | capHijacker | capReaderFrom:
+		return struct {
+			rw
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{f, f, f, f}
+	case capPusher:
+		return struct {
+			rw
+			http.Pusher
+		}{f, f}
+	case capFlusher | capPusher:
+		return struct {
+			rw
+			http.Flusher
+			http.Pusher
+		}{f, f, f}
+	case capHijacker | capPusher:
+		return struct {
+			rw
+			http.Hijacker
+			http.Pusher
+		}{f, f, f}
+	case capFlusher | capHijacker | capPusher:
+		return struct {
+			rw
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{f, f, f, f}
+	case capReaderFrom | capPusher:
+		return struct {
+			rw
+			io.ReaderFrom
+			http.Pusher
+		}{f, f, f}
+	case capFlusher | capReaderFrom | capPusher:
+		return struct {
+			rw
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{f, f, f, f}
+	case capHijacker | capReaderFrom | capPusher:
+		return struct {
+			rw
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{f, f, f, f}
+	default:
+		return struct {
+			rw
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{f, f, f, f, f}
+	}
+}
+
+// seenCapabilities returns the optional interfaces a handler finds on w
+func seenCapabilities(w http.ResponseWriter) int {
+	var caps int
+	if _, ok := w.(http.Flusher); ok {
+		caps |= capFlusher
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		caps |= capHijacker
+	}
+	if _, ok := w.(io.ReaderFrom); ok {
+		caps |= capReaderFrom
+	}
+	if _, ok := w.(http.Pusher); ok {
+		caps |= capPusher
+	}
+	return caps
+}
+
+func TestResponseWriterCapabilities(t *testing.T) {
+	names := map[int]string{capFlusher: "Flush", capHijacker: "Hijack", capReaderFrom: "ReadFrom", capPusher: "Push"}
+
+	for caps := 0; caps <= capAll; caps++ {
+		p := newTestPlugin(t, nil)
+		f := &fakeWriter{header: http.Header{}, calls: map[string]int{}}
+		raw := rawWriter(f, caps)
+
+		// what a handler sees without the plugin
+		want := seenCapabilities(raw)
+		if want != caps {
+			t.Fatalf("raw writer %04b exposes %04b", caps, want)
+		}
+
+		p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if got := seenCapabilities(w); got != want {
+				t.Errorf("caps %04b: handler sees %04b", caps, got)
+			}
+			if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || u.Unwrap() != raw {
+				t.Errorf("caps %04b: Unwrap does not return the wrapped writer", caps)
+			}
+
+			// every exposed interface reaches the wrapped writer
+			if fl, ok := w.(http.Flusher); ok {
+				fl.Flush()
+			}
+			if hj, ok := w.(http.Hijacker); ok {
+				_, _, _ = hj.Hijack()
+			}
+			if rf, ok := w.(io.ReaderFrom); ok {
+				_, _ = rf.ReadFrom(strings.NewReader("body"))
+			}
+			if ps, ok := w.(http.Pusher); ok {
+				_ = ps.Push("/app.css", nil)
+			}
+
+			// ResponseController finds the same support with and without the plugin
+			wantErr := http.NewResponseController(raw).Flush()
+			if err := http.NewResponseController(w).Flush(); (err == nil) != (wantErr == nil) {
+				t.Errorf("caps %04b: ResponseController.Flush = %v, want %v", caps, err, wantErr)
+			}
+		})).ServeHTTP(raw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		for bit, name := range names {
+			want := 0
+			if caps&bit != 0 {
+				want = 1
+				if name == "Flush" {
+					// both response controllers flushed as well
+					want = 3
+				}
+			}
+			if f.calls[name] != want {
+				t.Errorf("caps %04b: %s reached the writer %d times, want %d", caps, name, f.calls[name], want)
+			}
+		}
+	}
+}
+
+func TestResponseWriterReadFrom(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	path := filepath.Join(t.TempDir(), "download.bin")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, done := newTestServer(t, p, func(w http.ResponseWriter, _ *http.Request) {
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Error("handler does not see io.ReaderFrom")
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer file.Close()
+		// io.Copy takes the ReadFrom path of the writer
+		_, _ = io.Copy(w, file)
+	})
+
+	resp, err := srv.Client().Get(srv.URL + "/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	<-done
+
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded %d bytes, want %d", len(got), len(content))
+	}
+	labels := map[string]string{"method": "GET", "endpoint": "other", "status": "200"}
+	if sum := histogramSum(t, p, "rr_http_response_size_bytes", labels); sum != float64(len(content)) {
+		t.Errorf("response_size_bytes sum = %v, want %d", sum, len(content))
+	}
+}
+
+func TestResponseWriterController(t *testing.T) {
+	p := newTestPlugin(t, nil)
+
+	srv, done := newTestServer(t, p, func(w http.ResponseWriter, _ *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			t.Errorf("SetWriteDeadline: %v", err)
+		}
+		if err := rc.SetReadDeadline(time.Now().Add(time.Minute)); err != nil {
+			t.Errorf("SetReadDeadline: %v", err)
+		}
+		if err := rc.EnableFullDuplex(); err != nil {
+			t.Errorf("EnableFullDuplex: %v", err)
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	<-done
+}